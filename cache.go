@@ -3,32 +3,71 @@ package mapx
 import (
 	"reflect"
 	"sort"
+	"strings"
 	"sync"
 )
 
 var fieldCache sync.Map
 
 type typeKey struct {
-	tag string
+	tag    string
+	naming *KeyNaming
 	reflect.Type
 }
 
 func cachedFields(k typeKey) fields {
+	return cachedType(k).fields()
+}
+
+// cachedType returns the cache entry of k. Its fields are built on first use,
+// so recursive types can refer to their own entries while they are built.
+func cachedType(k typeKey) *typeFields {
 	if v, ok := fieldCache.Load(k); ok {
-		return v.(fields)
+		return v.(*typeFields)
 	}
 
-	v, _ := fieldCache.LoadOrStore(k, buildFields(k))
-	return v.(fields)
+	v, _ := fieldCache.LoadOrStore(k, &typeFields{key: k})
+	return v.(*typeFields)
+}
+
+type typeFields struct {
+	key  typeKey
+	once sync.Once
+	fs   fields
+
+	defaultsOnce sync.Once
+	defaults     bool
+}
+
+// fields returns the fields of the struct type, building them if needed. It
+// returns nil if tf is nil.
+func (tf *typeFields) fields() fields {
+	if tf == nil {
+		return nil
+	}
+
+	tf.once.Do(func() { tf.fs = buildFields(tf.key) })
+	return tf.fs
 }
 
 type field struct {
 	name     string
+	goName   string
 	baseType reflect.Type
 	typ      reflect.Type
 	tag      tag
 	index    []int
-	fields   fields
+
+	// nested holds the fields of the struct type the field consists of, if
+	// any.
+	nested *typeFields
+}
+
+// defaults reports whether the field has a default value or it's a struct,
+// which fields have defaults.
+func (f field) defaults() bool {
+	return f.tag.hasDefault ||
+		(f.baseType.Kind() == reflect.Struct && !f.tag.raw && f.nested.hasDefaults())
 }
 
 type fields []field
@@ -46,20 +85,114 @@ func (fs fields) Less(i, j int) bool {
 	return len(fs[i].index) < len(fs[j].index)
 }
 
-type fieldMap map[string]fields
+// hasDefaults reports whether defaults should be applied to the struct, even
+// if it's missing from the input. It returns false if tf is nil.
+//
+// It must not be called while fields are built, the struct can be recursive
+// through embedded pointers.
+func (tf *typeFields) hasDefaults() bool {
+	if tf == nil {
+		return false
+	}
 
-func (m fieldMap) insert(f field) {
-	if f.typ.Kind() == reflect.Struct {
-		f.fields = cachedFields(typeKey{
-			tag:  f.tag.tagname,
-			Type: f.typ,
-		})
+	tf.defaultsOnce.Do(func() { tf.defaults = hasDefaults(tf, make(map[*typeFields]bool)) })
+	return tf.defaults
+}
+
+// hasDefaults reports whether tf or any of the structs it holds by value have
+// defaults. seen holds structs that were already inspected, they are reached
+// again by recursive types.
+func hasDefaults(tf *typeFields, seen map[*typeFields]bool) bool {
+	if reflect.PointerTo(tf.key.Type).Implements(defaulterType) {
+		return true
+	}
+
+	seen[tf] = true
+	for _, f := range tf.fields() {
+		switch {
+		case f.tag.hasDefault:
+			return true
+		case f.baseType.Kind() != reflect.Struct || f.tag.raw || f.nested == nil || seen[f.nested]:
+		case hasDefaults(f.nested, seen):
+			return true
+		}
+	}
+	return false
+}
+
+// has reports whether name is the key of any field. Remain fields have no
+// keys.
+func (fs fields) has(name string) bool {
+	for _, f := range fs {
+		if f.name == name && !f.tag.remain {
+			return true
+		}
 	}
+	return false
+}
 
-	if f.typ.Kind() == reflect.Slice && f.typ.Elem().Kind() == reflect.Struct {
-		f.fields = cachedFields(typeKey{
-			tag:  f.tag.tagname,
-			Type: f.typ.Elem(),
+// remain returns the field that holds keys that don't match other fields.
+func (fs fields) remain() (field, bool) {
+	for _, f := range fs {
+		if f.tag.remain {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// closest returns the name of the field that is the most similar to name. It
+// returns an empty string if none of the names is close enough.
+func (fs fields) closest(name string) (out string) {
+	best := len(name)/2 + 1
+	for _, f := range fs {
+		if f.tag.remain {
+			continue
+		}
+		if d := levenshtein(strings.ToLower(name), strings.ToLower(f.name)); d < best {
+			best, out = d, f.name
+		}
+	}
+	return out
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if n := prev[j] + 1; n < curr[j] {
+				curr[j] = n
+			}
+			if n := curr[j-1] + 1; n < curr[j] {
+				curr[j] = n
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+type fieldMap map[string]fields
+
+func (m fieldMap) insert(f field) {
+	if typ := structType(f.typ); typ != nil {
+		f.nested = cachedType(typeKey{
+			tag:    f.tag.tagname,
+			naming: f.tag.naming,
+			Type:   typ,
 		})
 	}
 
@@ -143,12 +276,20 @@ func buildFields(k typeKey) fields {
 				}
 			}
 
-			tag := parseTag(k.tag, sf)
+			tag := parseTag(k, sf)
 			if tag.ignore {
 				continue
 			}
-			if f.tag.prefix != "" {
+			switch {
+			case f.tag.prefix == "":
+			case tag.prefix == "":
+				tag.prefix = f.tag.prefix
+			case k.naming == nil:
+				// nested inline field, kept as it was before key naming.
 				tag.prefix += f.tag.prefix
+			default:
+				// nested inline field, the outer prefix comes first.
+				tag.prefix = k.naming.key(f.tag.prefix, tag.prefix)
 			}
 
 			ft := sf.Type
@@ -157,7 +298,8 @@ func buildFields(k typeKey) fields {
 			}
 
 			newf := field{
-				name:     tag.prefix + tag.name,
+				name:     k.naming.key(tag.prefix, tag.name),
+				goName:   joinGoName(f.goName, sf.Name),
 				baseType: sf.Type,
 				typ:      ft,
 				tag:      tag,
@@ -165,6 +307,8 @@ func buildFields(k typeKey) fields {
 			}
 
 			if sf.Anonymous && ft.Kind() == reflect.Struct && tag.empty {
+				// fields of embedded structs are promoted.
+				newf.goName = f.goName
 				q = append(q, newf)
 				continue
 			}
@@ -185,7 +329,8 @@ func buildFields(k typeKey) fields {
 				if v.typ == f.typ && v.tag.prefix == tag.prefix {
 					// other nodes can have different path.
 					fm.insert(field{
-						name:     tag.prefix + tag.name,
+						name:     k.naming.key(tag.prefix, tag.name),
+						goName:   joinGoName(v.goName, sf.Name),
 						baseType: sf.Type,
 						typ:      ft,
 						tag:      tag,
@@ -198,6 +343,29 @@ func buildFields(k typeKey) fields {
 	return fm.fields()
 }
 
+// structType returns the struct type typ consists of: the type itself or the
+// element type of pointers, slices, arrays and maps. It returns nil if there is
+// no such type.
+func structType(typ reflect.Type) reflect.Type {
+	for {
+		switch typ.Kind() {
+		case reflect.Struct:
+			return typ
+		case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+			typ = typ.Elem()
+		default:
+			return nil
+		}
+	}
+}
+
+func joinGoName(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
 func makeIndex(index []int, v int) []int {
 	out := make([]int, len(index), len(index)+1)
 	copy(out, index)
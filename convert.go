@@ -0,0 +1,197 @@
+package mapx
+
+import (
+	"errors"
+	"math"
+	"reflect"
+)
+
+var (
+	ErrOverflow             = errors.New("mapx: value overflows destination type")
+	ErrNegativeUnsigned     = errors.New("mapx: negative value for unsigned type")
+	ErrPrecisionLoss        = errors.New("mapx: conversion loses precision")
+	ErrRuneConversion       = errors.New("mapx: integer to string conversion")
+	ErrConversionNotAllowed = errors.New("mapx: conversion is not allowed")
+)
+
+// Conversion is a conversion from one kind to another.
+type Conversion struct {
+	From, To reflect.Kind
+}
+
+// ConversionPolicy controls how the decoder converts between basic kinds
+// (bool, numeric and string kinds). The zero value rejects conversions that
+// change the value and allows all kind to kind conversions.
+type ConversionPolicy struct {
+	// AllowOverflow allows values that don't fit the destination type, including
+	// negative values decoded into unsigned types. They wrap around.
+	AllowOverflow bool
+
+	// AllowPrecisionLoss allows truncating floats decoded into integers and
+	// integers that can't be represented exactly by the destination float.
+	AllowPrecisionLoss bool
+
+	// AllowRuneConversion allows integers to be decoded into strings the same
+	// way Go converts them, i.e. 65 becomes "A".
+	AllowRuneConversion bool
+
+	// Allowed lists conversions between different basic kinds that the decoder
+	// may perform. If it is nil, all conversions are allowed. Default values
+	// from tags are not restricted.
+	Allowed []Conversion
+}
+
+func (cp ConversionPolicy) allowedSet() map[Conversion]struct{} {
+	if cp.Allowed == nil {
+		return nil
+	}
+
+	m := make(map[Conversion]struct{}, len(cp.Allowed))
+	for _, c := range cp.Allowed {
+		m[c] = struct{}{}
+	}
+	return m
+}
+
+func isBasicKind(k reflect.Kind) bool {
+	return k == reflect.Bool || k == reflect.String || isNumberKind(k)
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return isIntKind(k) || isUintKind(k) || k == reflect.Float32 || k == reflect.Float64
+}
+
+func isIntKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUintKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// canConvertNumber reports whether convertNumber supports val and dst kinds.
+func canConvertNumber(from, to reflect.Kind) bool {
+	return isNumberKind(from) && (isNumberKind(to) || to == reflect.String && !isFloatKind(from))
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// convertNumber sets a numeric val to dst, checking that the value is not
+// changed by the conversion, unless the policy says otherwise.
+func convertNumber(val, dst reflect.Value, cp ConversionPolicy) error {
+	switch k := val.Kind(); {
+	case isIntKind(k):
+		return convertInt(val.Int(), dst, cp)
+	case isUintKind(k):
+		return convertUint(val.Uint(), dst, cp)
+	default:
+		return convertFloat(val.Float(), dst, cp)
+	}
+}
+
+func convertInt(n int64, dst reflect.Value, cp ConversionPolicy) error {
+	switch k := dst.Kind(); {
+	case isIntKind(k):
+		if !cp.AllowOverflow && dst.OverflowInt(n) {
+			return ErrOverflow
+		}
+		dst.SetInt(n)
+	case isUintKind(k):
+		if !cp.AllowOverflow {
+			if n < 0 {
+				return ErrNegativeUnsigned
+			}
+			if dst.OverflowUint(uint64(n)) {
+				return ErrOverflow
+			}
+		}
+		dst.SetUint(uint64(n))
+	case isFloatKind(k):
+		f := float64(n)
+		if k == reflect.Float32 {
+			f = float64(float32(n))
+		}
+		if !cp.AllowPrecisionLoss && (f >= math.MaxInt64 || int64(f) != n) {
+			return ErrPrecisionLoss
+		}
+		dst.SetFloat(f)
+	default:
+		if !cp.AllowRuneConversion {
+			return ErrRuneConversion
+		}
+		dst.SetString(string(rune(n)))
+	}
+	return nil
+}
+
+func convertUint(n uint64, dst reflect.Value, cp ConversionPolicy) error {
+	switch k := dst.Kind(); {
+	case isIntKind(k):
+		if !cp.AllowOverflow && (n > math.MaxInt64 || dst.OverflowInt(int64(n))) {
+			return ErrOverflow
+		}
+		dst.SetInt(int64(n))
+	case isUintKind(k):
+		if !cp.AllowOverflow && dst.OverflowUint(n) {
+			return ErrOverflow
+		}
+		dst.SetUint(n)
+	case isFloatKind(k):
+		f := float64(n)
+		if k == reflect.Float32 {
+			f = float64(float32(n))
+		}
+		if !cp.AllowPrecisionLoss && (f >= math.MaxUint64 || uint64(f) != n) {
+			return ErrPrecisionLoss
+		}
+		dst.SetFloat(f)
+	default:
+		if !cp.AllowRuneConversion {
+			return ErrRuneConversion
+		}
+		dst.SetString(string(rune(n)))
+	}
+	return nil
+}
+
+func convertFloat(f float64, dst reflect.Value, cp ConversionPolicy) error {
+	switch k := dst.Kind(); {
+	case isIntKind(k):
+		if !cp.AllowOverflow && (math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 || dst.OverflowInt(int64(f))) {
+			return ErrOverflow
+		}
+		if !cp.AllowPrecisionLoss && math.Trunc(f) != f {
+			return ErrPrecisionLoss
+		}
+		dst.SetInt(int64(f))
+	case isUintKind(k):
+		if !cp.AllowOverflow {
+			if f < 0 {
+				return ErrNegativeUnsigned
+			}
+			if math.IsNaN(f) || f >= math.MaxUint64 || dst.OverflowUint(uint64(f)) {
+				return ErrOverflow
+			}
+		}
+		if !cp.AllowPrecisionLoss && math.Trunc(f) != f {
+			return ErrPrecisionLoss
+		}
+		dst.SetUint(uint64(f))
+	default:
+		if !cp.AllowOverflow && !math.IsInf(f, 0) && dst.OverflowFloat(f) {
+			return ErrOverflow
+		}
+		dst.SetFloat(f)
+	}
+	return nil
+}
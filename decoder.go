@@ -1,9 +1,13 @@
 package mapx
 
 import (
+	"encoding"
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 var defaultDecoder = NewDecoder[any](DecoderOpt{})
@@ -13,16 +17,103 @@ var mapType = reflect.TypeOf((*map[string]any)(nil)).Elem()
 type DecoderOpt struct {
 	DecoderFuncs DecoderFuncs
 	Tag          string
+
+	// KeyNaming converts names of untagged fields into keys, e.g. SnakeCase.
+	KeyNaming *KeyNaming
+
+	// NormalizeKey makes the decoder match input keys with field keys after
+	// normalizing both, e.g. CaseInsensitive. If it is nil keys must match
+	// exactly.
+	NormalizeKey KeyNormalizer
+
+	// AllErrors makes the decoder continue after a field fails to decode.
+	// Decode then returns Errors that lists every problem found.
+	AllErrors bool
+
+	// WeaklyTypedInput makes the decoder convert between strings, numbers and
+	// bools, e.g. "10" is decoded into an int field and 10 into a string
+	// field. Empty strings are decoded as zero values. Registered DecoderFuncs
+	// take priority.
+	WeaklyTypedInput bool
+
+	// Conversions controls conversions between basic kinds. By default values
+	// that would overflow or lose precision are rejected.
+	Conversions ConversionPolicy
+
+	// DisallowUnknownKeys makes the decoder return UnknownKeyError for every
+	// key that doesn't match any field of the destination struct, including
+	// nested structs and slice elements.
+	DisallowUnknownKeys bool
+
+	// NilPolicy defines how nil values are decoded, both into fields and into
+	// elements of slices and maps.
+	NilPolicy DecodeNilPolicy
+
+	// EmptyStringAsNil makes the decoder treat empty strings decoded into
+	// pointers as nil values.
+	EmptyStringAsNil bool
+
+	// StdInterfaces selects standard library interfaces used to decode values,
+	// e.g. TextInterfaces decodes strings into time.Time.
+	StdInterfaces StdInterfaces
+
+	// DecodeIntoInterfaces makes the decoder decode into the value an
+	// interface points to, if it holds a non-nil pointer to a struct or to a
+	// type with registered decoder funcs. Otherwise the interface is replaced
+	// with the input.
+	DecodeIntoInterfaces bool
+
+	// Merge controls decoding into slices, maps and pointers that are already
+	// set, e.g. to layer configs.
+	Merge MergePolicy
+
+	// Types holds concrete types of interfaces, which are picked by the
+	// discriminator key of the input.
+	Types Types
+
+	// References makes the decoder rebuild pointers written by the encoder
+	// with EncoderOpt.References: maps with RefKey are decoded into the
+	// pointer of the struct with the same RefIDKey.
+	References bool
 }
 
 type DecodeError struct {
+	// Key is the path of map keys leading to the value, e.g. "items[3].price".
+	Key string
+	// Field is the path of Go struct fields the key maps to, e.g.
+	// "Items[3].Price".
+	Field string
 	Value any
 	Type  reflect.Type
+	// Err is the error returned by a decoder func, if any.
+	Err error
+}
+
+func newDecodeError(p keyPath, v any, typ reflect.Type, err error) *DecodeError {
+	return &DecodeError{
+		Key:   p.keys(),
+		Field: p.fields(),
+		Value: v,
+		Type:  typ,
+		Err:   err,
+	}
 }
 
 // Error implements error interface.
 func (e *DecodeError) Error() string {
-	return fmt.Sprintf("mapx: cannot decode value of type %T into %s", e.Value, e.Type)
+	msg := fmt.Sprintf("mapx: cannot decode value of type %T into %s", e.Value, e.Type)
+	if e.Key != "" {
+		msg += fmt.Sprintf(" (key %q, field %s)", e.Key, e.Field)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the error returned by a decoder func.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
 }
 
 // Is implements errors.Is interface.
@@ -32,22 +123,114 @@ func (e *DecodeError) Is(err error) bool {
 		e != nil &&
 		errors.As(err, &derr) &&
 		derr.Type == e.Type &&
-		derr.Value == e.Value
+		reflect.DeepEqual(derr.Value, e.Value)
+}
+
+type UnknownKeyError struct {
+	// Key is the path of map keys leading to the unknown key.
+	Key string
+	// Suggestion is the closest known key, if any was similar enough.
+	Suggestion string
+}
+
+// Error implements error interface.
+func (e *UnknownKeyError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("mapx: unknown key %q (did you mean %q?)", e.Key, e.Suggestion)
+	}
+	return fmt.Sprintf("mapx: unknown key %q", e.Key)
 }
 
+// AmbiguousKeyError is returned when more than one input key matches the same
+// field after normalization.
+type AmbiguousKeyError struct {
+	// Key is the path of map keys leading to the field key.
+	Key string
+	// Keys are the conflicting input keys in sorted order.
+	Keys []string
+}
+
+// Error implements error interface.
+func (e *AmbiguousKeyError) Error() string {
+	return fmt.Sprintf("mapx: ambiguous keys %q for key %q", e.Keys, e.Key)
+}
+
+// RequiredKeyError is returned when a field tagged with required option is
+// missing from the input, or its value is nil and the field can't hold nil.
+type RequiredKeyError struct {
+	// Key is the path of map keys leading to the missing key.
+	Key string
+	// Field is the path of Go struct fields the key maps to.
+	Field string
+}
+
+// Error implements error interface.
+func (e *RequiredKeyError) Error() string {
+	return fmt.Sprintf("mapx: required key %q (field %s) is missing", e.Key, e.Field)
+}
+
+func newRequiredKeyError(p keyPath) *RequiredKeyError {
+	return &RequiredKeyError{
+		Key:   p.keys(),
+		Field: p.fields(),
+	}
+}
+
+// Defaulter is implemented by structs that set their own default values.
+// SetDefaults is called before a struct that is still zero is decoded,
+// including nested structs that are missing from the input. Defaults from tags
+// are applied after it, only to fields that are still zero.
+//
+// A tag default is written as `mapx:"port,default=8080"`. It can contain
+// commas, e.g. a list `mapx:"tags,default=a,b"`, up to the next known option,
+// so `mapx:"port,default=8080,required"` is a required field.
+type Defaulter interface {
+	SetDefaults()
+}
+
+var defaulterType = reflect.TypeOf((*Defaulter)(nil)).Elem()
+
 type Decoder[T any] struct {
-	opt    DecoderOpt
-	fields fields
+	opt         DecoderOpt
+	fields      fields
+	conversions map[Conversion]struct{}
 }
 
 func NewDecoder[T any](opts DecoderOpt) *Decoder[T] {
 	return &Decoder[T]{
-		opt:    opts,
-		fields: structFields[T](opts.Tag),
+		opt:         opts,
+		fields:      structFields[T](opts.Tag, opts.KeyNaming),
+		conversions: opts.Conversions.allowedSet(),
 	}
 }
 
 func (dec *Decoder[T]) Decode(m map[string]any, v T) error {
+	return dec.decodeRoot(m, v, decodeState{allErrors: dec.opt.AllErrors})
+}
+
+// DecodeMetadata works like Decode and also reports which fields were set and
+// which input keys were used.
+func (dec *Decoder[T]) DecodeMetadata(m map[string]any, v T) (Metadata, error) {
+	var md Metadata
+	err := dec.decodeRoot(m, v, decodeState{allErrors: dec.opt.AllErrors, md: &md})
+	md.sort()
+	return md, err
+}
+
+// Validate decodes m into a new value of the type T points to and returns
+// Errors with all the problems found, as if AllErrors was set. Registered
+// funcs, Unmarshaler and Defaulter methods are called on the new value.
+func (dec *Decoder[T]) Validate(m map[string]any) error {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Pointer {
+		return ErrNotAPointer
+	}
+
+	v := reflect.New(typ.Elem()).Interface().(T)
+	return dec.decodeRoot(m, v, decodeState{allErrors: true})
+}
+
+func (dec *Decoder[T]) decodeRoot(m map[string]any, v T, s decodeState) error {
 	dst := reflect.ValueOf(v)
 
 	if dst.Kind() != reflect.Pointer {
@@ -55,211 +238,687 @@ func (dec *Decoder[T]) Decode(m map[string]any, v T) error {
 	}
 
 	dst = dst.Elem()
+	if ok, err := unmarshal(m, dst); ok {
+		if err != nil {
+			return newDecodeError(nil, m, dst.Type(), err)
+		}
+		return nil
+	}
+
 	if dst.Kind() != reflect.Struct {
 		return ErrNotAStruct
 	}
 
-	return dec.decode(m, dst, dec.fields)
+	if dec.opt.Merge.ZeroFirst {
+		dst.Set(reflect.Zero(dst.Type()))
+	}
+
+	if dec.opt.References {
+		s.refs = &decodeRefs{ptrs: make(map[string]reflect.Value)}
+	}
+
+	if err := dec.decode(&s, make(keyPath, 0, 8), m, dst, dec.fields); err != nil {
+		return err
+	}
+
+	if s.refs != nil {
+		if err := s.refs.err(&s); err != nil {
+			return err
+		}
+	}
+	return s.err()
+}
+
+// decodeState holds the state of a single Decode call.
+type decodeState struct {
+	allErrors bool
+	errs      Errors
+
+	// weak forces weakly typed input, i.e. when default values are decoded.
+	// The values come from tags, so Conversions.Allowed doesn't apply to
+	// them.
+	weak bool
+
+	// refs is set if references are enabled.
+	refs *decodeRefs
+
+	// md is set if metadata is collected.
+	md *Metadata
+
+	// typeKey is the discriminator key of the struct decoded next, which is
+	// not reported as unknown.
+	typeKey string
+}
+
+// fail records err if all errors are collected, otherwise it returns err,
+// which should stop decoding.
+func (s *decodeState) fail(err error) error {
+	if s.allErrors {
+		s.errs = append(s.errs, err)
+		return nil
+	}
+	return err
+}
+
+func (s *decodeState) err() error {
+	if len(s.errs) == 0 {
+		return nil
+	}
+	return s.errs
 }
 
-func (dec *Decoder[T]) decode(m map[string]any, dst reflect.Value, fields fields) error {
+func (dec *Decoder[T]) decode(s *decodeState, p keyPath, m map[string]any, dst reflect.Value, fields fields) error {
 	if fields == nil {
 		fields = cachedFields(typeKey{
-			tag:  defaultTag(dec.opt.Tag),
-			Type: dst.Type(),
+			tag:    defaultTag(dec.opt.Tag),
+			naming: dec.opt.KeyNaming,
+			Type:   dst.Type(),
 		})
 	}
 
-loop:
+	typeKey := s.typeKey
+	s.typeKey = ""
+
+	var idx keyIndex
+	if dec.opt.NormalizeKey != nil {
+		idx = newKeyIndex(m, dec.opt.NormalizeKey)
+	}
+
+	rf, remain := fields.remain()
+	if dec.opt.DisallowUnknownKeys && !remain {
+		if err := dec.checkUnknownKeys(s, p, m, idx, fields, typeKey); err != nil {
+			return err
+		}
+	}
+
+	if s.refs != nil {
+		s.refs.define(m, dst)
+	}
+
+	setDefaults(dst)
+
 	for _, f := range fields {
-		v, ok := m[f.name]
-		if !ok {
+		if f.tag.remain {
 			continue
 		}
 
-		val := reflect.ValueOf(v)
-		if !val.IsValid() {
-			if f.baseType.Kind() != reflect.Interface && f.baseType.Kind() != reflect.Pointer {
-				return &DecodeError{
-					Value: v,
-					Type:  f.baseType,
-				}
+		fp := append(p, fieldElem(f))
+
+		key, v, ok, conflicts := dec.lookup(m, idx, f)
+		if ok {
+			// report the key used by the input.
+			fp[len(fp)-1].key = key
+		}
+
+		if conflicts != nil {
+			if err := s.fail(&AmbiguousKeyError{Key: fp.keys(), Keys: conflicts}); err != nil {
+				return err
 			}
 			continue
 		}
 
-		typ := val.Type()
+		if !ok {
+			if s.md != nil {
+				s.md.Untouched = append(s.md.Untouched, fp.fields())
+			}
+
+			var err error
+			switch {
+			case f.tag.required:
+				err = s.fail(newRequiredKeyError(fp))
+			case f.defaults():
+				err = dec.decodeDefault(s, fp, f, dst)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
 
-		fv := fieldByIndex(dst, f.index, true)
+		errs := len(s.errs)
+		if err := dec.decodeField(s, fp, f, v, dst); err != nil {
+			return err
+		}
 
-		if f.baseType.Kind() == reflect.Pointer && typ.Kind() != reflect.Pointer && fv.IsNil() {
-			fv.Set(reflect.New(fv.Type().Elem()))
-			fv = fv.Elem()
+		if s.md != nil && len(s.errs) == errs {
+			// fields that failed to decode are not set.
+			s.md.set(fp)
 		}
+	}
+
+	if remain || s.md != nil {
+		unknown := dec.unknownKeys(m, idx, fields, typeKey)
+		if remain {
+			// unknown keys are kept instead of being reported.
+			dec.decodeRemain(m, unknown, dst, rf)
+		}
+		if s.md != nil {
+			s.md.unknown(p, unknown, rf, remain)
+		}
+	}
+
+	return nil
+}
+
+// decodeField decodes v, the input value of field f, into the struct dst.
+func (dec *Decoder[T]) decodeField(s *decodeState, p keyPath, f field, v any, dst reflect.Value) error {
+	if dec.isNil(v, f.baseType) {
+		if k := f.baseType.Kind(); f.tag.required && k != reflect.Pointer && k != reflect.Interface {
+			return s.fail(newRequiredKeyError(p))
+		}
+
+		// fields promoted through nil embedded pointers are already nil.
+		return dec.decodeNil(s, p, v, fieldByIndex(dst, f.index, false), f.baseType)
+	}
+
+	return dec.decodeValue(s, p, v, fieldByIndex(dst, f.index, true), f.nested.fields())
+}
+
+// decodeDefault sets the default value of f, which is missing from the
+// input. If f is a struct without a default value, defaults of its fields are
+// set instead.
+func (dec *Decoder[T]) decodeDefault(s *decodeState, p keyPath, f field, dst reflect.Value) error {
+	if fv := fieldByIndex(dst, f.index, false); f.tag.hasDefault && fv.IsValid() && !fv.IsZero() {
+		// the value was set before, e.g. by a previous Decode.
+		return nil
+	}
+
+	fv := fieldByIndex(dst, f.index, true)
 
-		if dec.opt.DecoderFuncs.m != nil {
-			if conv, ok := dec.opt.DecoderFuncs.m[typ]; ok && reflect.PointerTo(fv.Type()) == conv.dst {
-				if err := conv.f(v, fv.Addr().Interface()); err != nil {
-					return err
-				}
+	if !f.tag.hasDefault {
+		setDefaults(fv)
+
+		for _, sf := range f.nested.fields() {
+			if !sf.defaults() || !fieldByIndex(fv, sf.index, false).IsValid() {
+				// fields promoted through nil embedded pointers are left
+				// nil, the pointers can lead back to the struct itself.
 				continue
 			}
+			if err := dec.decodeDefault(s, append(p, fieldElem(sf)), sf, fv); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
 
-		if dec.opt.DecoderFuncs.ifaceFuncs != nil {
-			for _, fn := range dec.opt.DecoderFuncs.ifaceFuncs[typ] {
-				if f.typ.AssignableTo(fn.dst) {
-					if err := fn.f(v, fv.Interface()); err != nil {
-						return err
-					}
-					continue loop
-				}
-
-				if reflect.PtrTo(f.typ).AssignableTo(fn.dst) {
-					if err := fn.f(v, fv.Addr().Interface()); err != nil {
-						return err
-					}
-					continue loop
-				}
+	var v any = f.tag.defaultVal
+	if isList(f.typ) {
+		list := []any{}
+		if f.tag.defaultVal != "" {
+			for _, elem := range strings.Split(f.tag.defaultVal, ",") {
+				list = append(list, elem)
 			}
 		}
+		v = list
+	}
 
-		switch {
-		case typ == fv.Type() || canSet(fv.Type(), typ):
-			switch typ.Kind() {
-			case reflect.String:
-				fv.SetString(val.String())
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				fv.SetInt(val.Int())
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				fv.SetUint(val.Uint())
-			case reflect.Float64, reflect.Float32:
-				fv.SetFloat(val.Float())
-			default:
-				fv.Set(val)
-			}
-		case val.CanConvert(fv.Type()):
-			switch f.typ.Kind() {
-			case reflect.String:
-				fv.SetString(val.Convert(f.typ).String())
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				fv.SetInt(val.Convert(f.typ).Int())
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				fv.SetUint(val.Convert(f.typ).Uint())
-			case reflect.Float64, reflect.Float32:
-				fv.SetFloat(val.Convert(f.typ).Float())
-			default:
-				fv.Set(val.Convert(fv.Type()))
-			}
-		case fv.Type().Kind() == reflect.Slice && val.Type().Kind() == reflect.Slice:
-			slice, err := dec.decodeSlice(f, val)
-			if err != nil {
+	weak := s.weak
+	s.weak = true
+	defer func() { s.weak = weak }()
+
+	return dec.decodeValue(s, p, v, fv, f.nested.fields())
+}
+
+// isNil reports whether v is decoded into typ as nil.
+func (dec *Decoder[T]) isNil(v any, typ reflect.Type) bool {
+	if v == nil {
+		return true
+	}
+	if !dec.opt.EmptyStringAsNil || typ.Kind() != reflect.Pointer {
+		return false
+	}
+	val := reflect.ValueOf(v)
+	return val.Kind() == reflect.String && val.Len() == 0
+}
+
+// decodeNil decodes nil value v into dst of type typ according to NilPolicy. dst is
+// invalid if it is promoted through a nil embedded pointer.
+func (dec *Decoder[T]) decodeNil(s *decodeState, p keyPath, v any, dst reflect.Value, typ reflect.Type) error {
+	if !dec.opt.NilPolicy.acceptsNil(typ) {
+		var err error
+		if dec.opt.NilPolicy == DecodeNilError {
+			err = ErrNilValue
+		}
+		return s.fail(newDecodeError(p, v, typ, err))
+	}
+
+	if dec.opt.NilPolicy == DecodeNilZero && dst.IsValid() {
+		dst.Set(reflect.Zero(typ))
+	}
+	return nil
+}
+
+// setDefaults calls SetDefaults on the struct dst if it is still zero, so
+// values set before are not overwritten.
+func setDefaults(dst reflect.Value) {
+	if !dst.IsZero() {
+		return
+	}
+
+	if d, ok := dst.Addr().Interface().(Defaulter); ok {
+		d.SetDefaults()
+	}
+}
+
+// decodeValue decodes v into dst, which must be settable. fields are the
+// cached fields of the struct type dst consists of, if any. It is called for
+// struct fields, slice elements and map values alike.
+func (dec *Decoder[T]) decodeValue(s *decodeState, p keyPath, v any, dst reflect.Value, fields fields) error {
+	if dec.isNil(v, dst.Type()) {
+		return dec.decodeNil(s, p, v, dst, dst.Type())
+	}
+
+	if s.refs != nil {
+		if m, ok := v.(map[string]any); ok {
+			if done, err := dec.decodeRef(s, p, m, dst); done {
 				return err
 			}
-			fv.Set(slice)
-		case fv.Type().Kind() == reflect.Struct && typ.ConvertibleTo(mapType):
-			if err := dec.decode(val.Interface().(map[string]any), fv, f.fields); err != nil {
-				return err
+		}
+	}
+
+	val := reflect.ValueOf(v)
+	typ := val.Type()
+
+	for dst.Kind() == reflect.Pointer && typ.Kind() != reflect.Pointer {
+		if dst.IsNil() || dec.opt.Merge.ReallocPointers && dst.Type().Elem().Kind() == reflect.Struct {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+
+	if ok, err := dec.decodeFunc(v, typ, dst); ok {
+		if err != nil {
+			return s.fail(newDecodeError(p, v, dst.Type(), err))
+		}
+		return nil
+	}
+
+	if ok, err := unmarshal(v, dst); ok {
+		if err != nil {
+			return s.fail(newDecodeError(p, v, dst.Type(), err))
+		}
+		return nil
+	}
+
+	if dec.opt.DecodeIntoInterfaces && dst.Kind() == reflect.Interface {
+		if elem, ok := dec.interfaceValue(typ, dst); ok {
+			return dec.decodeValue(s, p, v, elem, nil)
+		}
+	}
+
+	if m, ok := v.(map[string]any); ok && dst.Kind() == reflect.Interface {
+		if ok, err := dec.decodeType(s, p, m, dst); ok {
+			return err
+		}
+	}
+
+	if typ != dst.Type() {
+		if ok, err := dec.opt.StdInterfaces.decode(v, dst); ok {
+			if err != nil {
+				return s.fail(newDecodeError(p, v, dst.Type(), err))
 			}
-		default:
-			return &DecodeError{
-				Value: v,
-				Type:  fv.Type(),
+			return nil
+		}
+	}
+
+	dt := dst.Type()
+	if typ != dt && !s.weak && !dec.conversionAllowed(typ.Kind(), dt.Kind()) {
+		return s.fail(newDecodeError(p, v, dt, ErrConversionNotAllowed))
+	}
+
+	if (dec.opt.WeaklyTypedInput || s.weak) && typ != dt && !canSet(dt, typ) {
+		if ok, err := decodeWeak(val, dst); ok {
+			if err != nil {
+				return s.fail(newDecodeError(p, v, dt, err))
 			}
+			return nil
 		}
 	}
 
+	switch {
+	case typ == dt:
+		dst.Set(val)
+	case canConvertNumber(typ.Kind(), dt.Kind()):
+		if err := convertNumber(val, dst, dec.opt.Conversions); err != nil {
+			return s.fail(newDecodeError(p, v, dt, err))
+		}
+	case dt.Kind() == reflect.Array && isList(typ):
+		// before conversion, which truncates slices longer than the array.
+		return dec.decodeArray(s, p, val, dst, fields)
+	case val.CanConvert(dt):
+		dst.Set(val.Convert(dt))
+	case dt.Kind() == reflect.Slice && isList(typ):
+		return dec.decodeSlice(s, p, val, dst, fields)
+	case dt.Kind() == reflect.Map && typ.Kind() == reflect.Map:
+		return dec.decodeMap(s, p, val, dst, fields)
+	case dt.Kind() == reflect.Struct && typ.ConvertibleTo(mapType):
+		return dec.decode(s, p, val.Convert(mapType).Interface().(map[string]any), dst, fields)
+	default:
+		return s.fail(newDecodeError(p, v, dt, nil))
+	}
 	return nil
 }
 
-func (dec *Decoder[T]) decodeSlice(f field, val reflect.Value) (reflect.Value, error) {
+// conversionAllowed reports whether ConversionPolicy allows converting
+// between the two kinds.
+func (dec *Decoder[T]) conversionAllowed(from, to reflect.Kind) bool {
+	if dec.conversions == nil || from == to || !isBasicKind(from) || !isBasicKind(to) {
+		return true
+	}
+
+	_, ok := dec.conversions[Conversion{From: from, To: to}]
+	return ok
+}
+
+// interfaceValue returns the value the interface dst points to, if input of
+// type typ can be decoded into it.
+func (dec *Decoder[T]) interfaceValue(typ reflect.Type, dst reflect.Value) (reflect.Value, bool) {
+	if dst.IsNil() {
+		return reflect.Value{}, false
+	}
+
+	ptr := dst.Elem()
+	if ptr.Kind() != reflect.Pointer || ptr.IsNil() {
+		return reflect.Value{}, false
+	}
+
+	elem := ptr.Elem()
+	switch {
+	case elem.Kind() == reflect.Struct && typ.ConvertibleTo(mapType):
+	case dec.hasFunc(typ, elem.Type()):
+	default:
+		return reflect.Value{}, false
+	}
+	return elem, true
+}
+
+// hasFunc reports whether there is a registered decoder func for values of
+// typ and destination of type dt.
+func (dec *Decoder[T]) hasFunc(typ, dt reflect.Type) bool {
+	if _, ok := dec.opt.DecoderFuncs.m[decoderKey{typ, reflect.PointerTo(dt)}]; ok {
+		return true
+	}
+
+	for _, fn := range dec.opt.DecoderFuncs.ifaceFuncs[typ] {
+		if dt.AssignableTo(fn.dst) || reflect.PointerTo(dt).AssignableTo(fn.dst) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeFunc runs a registered decoder func for v and dst. It reports whether
+// any func was found.
+func (dec *Decoder[T]) decodeFunc(v any, typ reflect.Type, dst reflect.Value) (bool, error) {
+	if dec.opt.DecoderFuncs.m != nil {
+		if conv, ok := dec.opt.DecoderFuncs.m[decoderKey{typ, reflect.PointerTo(dst.Type())}]; ok {
+			return true, conv.f(v, dst.Addr().Interface())
+		}
+	}
+
+	for _, fn := range dec.opt.DecoderFuncs.ifaceFuncs[typ] {
+		switch {
+		case dst.Type().AssignableTo(fn.dst):
+			return true, fn.f(v, dst.Interface())
+		case reflect.PointerTo(dst.Type()).AssignableTo(fn.dst):
+			return true, fn.f(v, dst.Addr().Interface())
+		}
+	}
+	return false, nil
+}
+
+func (dec *Decoder[T]) decodeSlice(s *decodeState, p keyPath, val, dst reflect.Value, fields fields) error {
 	var (
-		l          = val.Len()
-		slice      = reflect.MakeSlice(f.typ, l, l)
-		elemType   = f.typ.Elem()
-		shouldInit = elemType.Kind() == reflect.Pointer
+		l     = val.Len()
+		slice = reflect.MakeSlice(dst.Type(), l, l)
 	)
 
-sliceLoop:
 	for i := 0; i < l; i++ {
-		val := val.Index(i)
-		if val.Type().Kind() == reflect.Interface {
-			val = val.Elem()
+		if err := dec.decodeValue(s, append(p, indexElem(i)), val.Index(i).Interface(), slice.Index(i), fields); err != nil {
+			return err
 		}
+	}
+
+	if dec.opt.Merge.AppendSlices && !dst.IsNil() {
+		slice = reflect.AppendSlice(dst, slice)
+	}
+
+	dst.Set(slice)
+	return nil
+}
+
+func (dec *Decoder[T]) decodeArray(s *decodeState, p keyPath, val, dst reflect.Value, fields fields) error {
+	if l := dst.Len(); val.Len() != l {
+		err := fmt.Errorf("%w: want %d elements; got %d", ErrArrayLength, l, val.Len())
+		return s.fail(newDecodeError(p, val.Interface(), dst.Type(), err))
+	}
+
+	arr := reflect.New(dst.Type()).Elem()
+	for i := 0; i < arr.Len(); i++ {
+		if err := dec.decodeValue(s, append(p, indexElem(i)), val.Index(i).Interface(), arr.Index(i), fields); err != nil {
+			return err
+		}
+	}
+
+	dst.Set(arr)
+	return nil
+}
+
+func isList(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array
+}
+
+func (dec *Decoder[T]) decodeMap(s *decodeState, p keyPath, val, dst reflect.Value, fields fields) error {
+	var (
+		typ   = dst.Type()
+		out   = dst
+		merge = dec.opt.Merge.MergeMaps && !dst.IsNil()
+	)
+
+	if !merge {
+		out = reflect.MakeMapWithSize(typ, val.Len())
+	}
+
+	for iter := val.MapRange(); iter.Next(); {
+		k := iter.Key()
+		kp := append(p, mapKeyElem(k))
 
-		if !val.IsValid() {
-			if typ, k := elemType, elemType.Kind(); k != reflect.Interface && k != reflect.Pointer {
-				return reflect.Value{}, &DecodeError{
-					Value: nil,
-					Type:  typ,
-				}
+		key, err := decodeMapKey(k, typ.Key(), dec.opt.Conversions)
+		if err != nil {
+			if err := s.fail(newDecodeError(kp, k.Interface(), typ.Key(), err)); err != nil {
+				return err
 			}
 			continue
 		}
 
-		dst := slice.Index(i)
-		if shouldInit {
-			dst.Set(reflect.New(elemType.Elem()))
+		// map elements are not addressable.
+		elem := reflect.New(typ.Elem()).Elem()
+		if merge {
+			if v := out.MapIndex(key); v.IsValid() {
+				elem.Set(v)
+			}
 		}
 
-		switch {
-		case dec.opt.DecoderFuncs.m != nil:
-			if conv, ok := dec.opt.DecoderFuncs.m[val.Type()]; ok && reflect.PointerTo(f.typ.Elem()) == conv.dst {
-				if err := conv.f(val.Interface(), dst.Addr().Interface()); err != nil {
-					return reflect.Value{}, err
-				}
-				continue
-			}
-		case dec.opt.DecoderFuncs.ifaceFuncs != nil:
-			for _, fn := range dec.opt.DecoderFuncs.ifaceFuncs[val.Type()] {
-				if f.typ.Elem().AssignableTo(fn.dst) {
-					if err := fn.f(val.Interface(), dst.Interface()); err != nil {
-						return reflect.Value{}, err
-					}
-					continue sliceLoop
-				}
-
-				if reflect.PtrTo(f.typ.Elem()).AssignableTo(fn.dst) {
-					if err := fn.f(val.Interface(), dst.Addr().Interface()); err != nil {
-						return reflect.Value{}, err
-					}
-					continue sliceLoop
-				}
-			}
+		if err := dec.decodeValue(s, kp, iter.Value().Interface(), elem, fields); err != nil {
+			return err
 		}
+		out.SetMapIndex(key, elem)
+	}
 
-		switch {
-		case val.CanConvert(f.typ.Elem()):
-			dst.Set(val.Convert(f.typ.Elem()))
-		case shouldInit && val.CanConvert(f.typ.Elem().Elem()):
-			dst.Elem().Set(val.Convert(dst.Type().Elem()))
-		case val.Type().ConvertibleTo(mapType):
-			if err := dec.decode(val.Interface().(map[string]any), dst, f.fields); err != nil {
-				return reflect.Value{}, err
-			}
-		default:
-			return reflect.Value{}, &DecodeError{
-				Value: val.Interface(),
-				Type:  f.baseType.Elem(),
+	if !merge {
+		dst.Set(out)
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// decodeMapKey converts k to typ. String keys are parsed the same way
+// encoding/json parses object keys.
+func decodeMapKey(k reflect.Value, typ reflect.Type, cp ConversionPolicy) (reflect.Value, error) {
+	if k.Kind() == reflect.Interface {
+		k = k.Elem()
+	}
+
+	switch {
+	case !k.IsValid():
+		return reflect.Value{}, errors.New("nil key")
+	case k.Type() == typ:
+		return k, nil
+	case k.Kind() != reflect.String:
+		if canSet(typ, k.Type()) {
+			key := reflect.New(typ).Elem()
+			return key, convertNumber(k, key, cp)
+		}
+		return reflect.Value{}, errors.New("unsupported key type")
+	}
+
+	key := reflect.New(typ).Elem()
+	if reflect.PointerTo(typ).Implements(textUnmarshalerType) {
+		err := key.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(k.String()))
+		return key, err
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		key.SetString(k.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(k.String(), 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		key.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(k.String(), 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		key.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(k.String(), typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		key.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(k.String())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		key.SetBool(b)
+	default:
+		return reflect.Value{}, errors.New("unsupported key type")
+	}
+	return key, nil
+}
+
+// lookup returns the input key and the value of field f. If keys are normalized, idx must be
+// the index of m. If more than one key matches f, they are returned as
+// conflicts.
+func (dec *Decoder[T]) lookup(m map[string]any, idx keyIndex, f field) (key string, _ any, ok bool, conflicts []string) {
+	if idx == nil {
+		v, ok := m[f.name]
+		return f.name, v, ok, nil
+	}
+
+	keys := idx[dec.opt.NormalizeKey(f.name)]
+	switch len(keys) {
+	case 0:
+		return "", nil, false, nil
+	case 1:
+		return keys[0], m[keys[0]], true, nil
+	default:
+		return "", nil, false, keys
+	}
+}
+
+func (dec *Decoder[T]) checkUnknownKeys(s *decodeState, p keyPath, m map[string]any, idx keyIndex, fields fields, typeKey string) error {
+	unknown := dec.unknownKeys(m, idx, fields, typeKey)
+
+	// map iteration order is random, errors should not be.
+	sort.Strings(unknown)
+
+	for _, k := range unknown {
+		err := &UnknownKeyError{
+			Key:        append(p, pathElem{key: k}).keys(),
+			Suggestion: fields.closest(k),
+		}
+		if err := s.fail(err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unknownKeys returns keys of m that don't match any of the fields, except
+// for typeKey.
+func (dec *Decoder[T]) unknownKeys(m map[string]any, idx keyIndex, fields fields, typeKey string) []string {
+	has := fields.has
+	if idx != nil {
+		names := make(map[string]struct{}, len(fields))
+		for _, f := range fields {
+			if !f.tag.remain {
+				names[dec.opt.NormalizeKey(f.name)] = struct{}{}
 			}
 		}
+
+		has = func(k string) bool {
+			_, ok := names[dec.opt.NormalizeKey(k)]
+			return ok
+		}
 	}
-	return slice, nil
+
+	var unknown []string
+	for k := range m {
+		if k == RefIDKey && dec.opt.References || k == typeKey && typeKey != "" {
+			continue
+		}
+		if !has(k) {
+			unknown = append(unknown, k)
+		}
+	}
+	return unknown
+}
+
+// decodeRemain sets the remain field f to the unknown keys of m. The field is
+// left unchanged if there are none.
+func (dec *Decoder[T]) decodeRemain(m map[string]any, unknown []string, dst reflect.Value, f field) {
+	if len(unknown) == 0 {
+		return
+	}
+
+	remain := make(map[string]any, len(unknown))
+	for _, k := range unknown {
+		remain[k] = m[k]
+	}
+
+	fieldByIndex(dst, f.index, true).Set(reflect.ValueOf(remain).Convert(f.baseType))
 }
 
 func Decode[T any](m map[string]any, v *T) error {
 	return defaultDecoder.Decode(m, v)
 }
 
+// Validate reports all the problems found when decoding m into T, without
+// changing any existing value. See Decoder.Validate.
+func Validate[T any](m map[string]any, opts DecoderOpt) error {
+	return NewDecoder[*T](opts).Validate(m)
+}
+
 type DecoderFuncs struct {
-	m          map[reflect.Type]decoderFunc
+	m          map[decoderKey]decoderFunc
 	ifaceFuncs map[reflect.Type][]decoderFunc
 }
 
+// decoderKey is a pair of the input type and the destination type.
+type decoderKey struct {
+	src, dst reflect.Type
+}
+
 func (df DecoderFuncs) clone() DecoderFuncs {
-	var m map[reflect.Type]decoderFunc
+	var m map[decoderKey]decoderFunc
 	if df.m != nil {
-		m = make(map[reflect.Type]decoderFunc, len(df.m))
+		m = make(map[decoderKey]decoderFunc, len(df.m))
 		for k, v := range df.m {
 			m[k] = v
 		}
@@ -305,10 +964,10 @@ func RegisterDecoder[T, V any](df DecoderFuncs, f func(T, V) error) DecoderFuncs
 	}
 
 	if out.m == nil {
-		out.m = make(map[reflect.Type]decoderFunc)
+		out.m = make(map[decoderKey]decoderFunc)
 	}
 
-	out.m[ftyp.In(0)] = decoderFunc{
+	out.m[decoderKey{ftyp.In(0), ftyp.In(1)}] = decoderFunc{
 		dst: ftyp.In(1),
 		f:   func(v, dst any) error { return f(v.(T), dst.(V)) },
 	}
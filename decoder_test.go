@@ -2,8 +2,12 @@ package mapx_test
 
 import (
 	"encoding"
+	"errors"
+	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -46,6 +50,33 @@ func (n *Int) UnmarshalText(text []byte) error {
 	return nil
 }
 
+type Point struct {
+	X, Y int
+}
+
+type Limit struct {
+	Max int `mapx:"max"`
+}
+
+type Level int
+
+const (
+	LevelLow Level = iota
+	LevelHigh
+)
+
+func (l *Level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = LevelLow
+	case "high":
+		*l = LevelHigh
+	default:
+		return fmt.Errorf("invalid level: %s", text)
+	}
+	return nil
+}
+
 // the point is a value-receiver.
 type wrappedInt struct {
 	*Int
@@ -324,6 +355,23 @@ func TestDecode(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "nested inline prefixes",
+			m: map[string]any{
+				"inner_outer_B1": 100,
+			},
+			expected: &struct {
+				A struct {
+					B B `mapx:"inner_,inline"`
+				} `mapx:"outer_,inline"`
+			}{
+				A: struct {
+					B B `mapx:"inner_,inline"`
+				}{
+					B: B{B1: 100},
+				},
+			},
+		},
 		{
 			desc: "inline field conflict",
 			m: map[string]any{
@@ -361,6 +409,410 @@ func TestDecode(t *testing.T) {
 				Int: wrappedInt{ptr(Int(1))},
 			},
 		},
+		{
+			desc: "maps",
+			m: map[string]any{
+				"Labels": map[string]any{"env": "prod"},
+				"Limits": map[string]any{
+					"cpu": map[string]any{"max": 2},
+				},
+				"ByID":    map[string]any{"1": []any{1.0, 2}},
+				"ByLevel": map[string]any{"high": ptr(10)},
+				"Named":   map[string]string{"foo": "bar"},
+			},
+			expected: &struct {
+				Labels  map[string]string
+				Limits  map[string]Limit
+				ByID    map[int][]int
+				ByLevel map[Level]*int
+				Named   map[String]String
+			}{
+				Labels:  map[string]string{"env": "prod"},
+				Limits:  map[string]Limit{"cpu": {Max: 2}},
+				ByID:    map[int][]int{1: {1, 2}},
+				ByLevel: map[Level]*int{LevelHigh: ptr(10)},
+				Named:   map[String]String{"foo": "bar"},
+			},
+		},
+		{
+			desc: "maps with custom decoder",
+			m: map[string]any{
+				"Ints": map[string]any{"a": "1", "b": "2"},
+			},
+			opts: mapx.DecoderOpt{
+				DecoderFuncs: stringIntDecFuncs,
+			},
+			expected: &struct {
+				Ints map[string]int
+			}{
+				Ints: map[string]int{"a": 1, "b": 2},
+			},
+		},
+		{
+			desc: "arrays and nested slices",
+			m: map[string]any{
+				"Vec":     []any{1.5, 2, 3},
+				"VecArr":  [3]int{1, 2, 3},
+				"PtrArr":  []any{1, nil},
+				"Matrix":  []any{[]any{"a", "b"}, []string{"c"}},
+				"Points":  []any{[]any{map[string]any{"X": 1, "Y": 2}}},
+				"Deep":    []any{[]any{[]any{1, 2}}},
+				"Grid":    []any{[]any{1, 2}, []any{3, 4}},
+				"PPoints": [][]any{{map[string]any{"X": 1}, nil}},
+			},
+			expected: &struct {
+				Vec     [3]float64
+				VecArr  [3]float64
+				PtrArr  [2]*int
+				Matrix  [][]string
+				Points  [][]Point
+				Deep    [][][2]int
+				Grid    [2][2]int
+				PPoints [][]*Point
+			}{
+				Vec:     [3]float64{1.5, 2, 3},
+				VecArr:  [3]float64{1, 2, 3},
+				PtrArr:  [2]*int{ptr(1), nil},
+				Matrix:  [][]string{{"a", "b"}, {"c"}},
+				Points:  [][]Point{{{X: 1, Y: 2}}},
+				Deep:    [][][2]int{{{1, 2}}},
+				Grid:    [2][2]int{{1, 2}, {3, 4}},
+				PPoints: [][]*Point{{{X: 1}, nil}},
+			},
+		},
+		{
+			desc: "nested slices with custom decoder",
+			m: map[string]any{
+				"Ints": []any{[]any{"1"}, []string{"2", "3"}},
+				"Arr":  []any{"4", "5"},
+			},
+			opts: mapx.DecoderOpt{
+				DecoderFuncs: stringIntDecFuncs,
+			},
+			expected: &struct {
+				Ints [][]int
+				Arr  [2]int
+			}{
+				Ints: [][]int{{1}, {2, 3}},
+				Arr:  [2]int{4, 5},
+			},
+		},
+		{
+			desc: "error - array length",
+			m: map[string]any{
+				"Vec": []any{1, 2},
+			},
+			dst: &struct {
+				Vec [3]int
+			}{},
+			err: mapx.ErrArrayLength,
+		},
+		{
+			desc: "error - array length value",
+			m: map[string]any{
+				"Vec": []any{1, 2},
+			},
+			dst: &struct {
+				Vec [3]int
+			}{},
+			err: &mapx.DecodeError{
+				Value: []any{1, 2},
+				Type:  reflect.TypeOf([3]int{}),
+			},
+		},
+		{
+			desc: "error - longer than array",
+			m: map[string]any{
+				"Vec": []float64{1, 2, 3, 4},
+			},
+			dst: &struct {
+				Vec [3]float64
+			}{},
+			err: mapx.ErrArrayLength,
+		},
+		{
+			desc: "error - longer than array of any",
+			m: map[string]any{
+				"Vec": []any{1, 2, 3},
+			},
+			dst: &struct {
+				Vec [2]any
+			}{},
+			err: mapx.ErrArrayLength,
+		},
+		{
+			desc: "error - nested slice element",
+			m: map[string]any{
+				"Matrix": []any{[]any{"a", true}},
+			},
+			dst: &struct {
+				Matrix [][]string
+			}{},
+			err: &mapx.DecodeError{
+				Value: true,
+				Type:  reflect.TypeOf(""),
+			},
+		},
+		{
+			desc: "weakly typed input",
+			m: map[string]any{
+				"Int":      "-10",
+				"Uint":     "10",
+				"Float":    "1.5",
+				"Bool":     "true",
+				"Duration": "1m30s",
+				"String":   1.5,
+				"UString":  uint8(7),
+				"BString":  true,
+				"BInt":     true,
+				"IBool":    1,
+				"Empty":    "",
+				"EmptyPtr": "",
+				"Any":      "",
+				"Ints":     []any{"1", 2, ""},
+				"Map":      map[string]any{"a": "1"},
+				"Custom":   "5",
+			},
+			opts: mapx.DecoderOpt{
+				WeaklyTypedInput: true,
+				DecoderFuncs: mapx.RegisterDecoder(mapx.DecoderFuncs{}, func(s string, dst *Int) error {
+					*dst = 100
+					return nil
+				}),
+			},
+			dst: &struct {
+				Int      int8
+				Uint     uint
+				Float    float32
+				Bool     bool
+				Duration time.Duration
+				String   string
+				UString  string
+				BString  string
+				BInt     int
+				IBool    bool
+				Empty    int
+				EmptyPtr *float64
+				Any      any
+				Ints     []int
+				Map      map[string]uint
+				Custom   Int
+			}{
+				Empty: 10,
+			},
+			expected: &struct {
+				Int      int8
+				Uint     uint
+				Float    float32
+				Bool     bool
+				Duration time.Duration
+				String   string
+				UString  string
+				BString  string
+				BInt     int
+				IBool    bool
+				Empty    int
+				EmptyPtr *float64
+				Any      any
+				Ints     []int
+				Map      map[string]uint
+				Custom   Int
+			}{
+				Int:      -10,
+				Uint:     10,
+				Float:    1.5,
+				Bool:     true,
+				Duration: 90 * time.Second,
+				String:   "1.5",
+				UString:  "7",
+				BString:  "true",
+				BInt:     1,
+				IBool:    true,
+				EmptyPtr: ptr(0.0),
+				Any:      "",
+				Ints:     []int{1, 2, 0},
+				Map:      map[string]uint{"a": 1},
+				Custom:   100,
+			},
+		},
+		{
+			desc: "error - weakly typed input",
+			m: map[string]any{
+				"Int": "300",
+			},
+			opts: mapx.DecoderOpt{
+				WeaklyTypedInput: true,
+			},
+			dst: &struct {
+				Int int8
+			}{},
+			err: strconv.ErrRange,
+		},
+		{
+			desc: "conversions",
+			m: map[string]any{
+				"Int8":    int64(-128),
+				"Uint8":   255.0,
+				"Int":     uint64(10),
+				"Float32": 1 << 24,
+				"Float64": float32(1.5),
+			},
+			expected: &struct {
+				Int8    int8
+				Uint8   uint8
+				Int     int
+				Float32 float32
+				Float64 float64
+			}{
+				Int8:    -128,
+				Uint8:   255,
+				Int:     10,
+				Float32: 1 << 24,
+				Float64: 1.5,
+			},
+		},
+		{
+			desc: "conversions - lenient policy",
+			m: map[string]any{
+				"Int8":   int64(300),
+				"Int":    1.7,
+				"Uint":   -1,
+				"String": 65,
+			},
+			opts: mapx.DecoderOpt{
+				Conversions: mapx.ConversionPolicy{
+					AllowOverflow:       true,
+					AllowPrecisionLoss:  true,
+					AllowRuneConversion: true,
+				},
+			},
+			expected: &struct {
+				Int8   int8
+				Int    int
+				Uint   uint8
+				String string
+			}{
+				Int8:   44,
+				Int:    1,
+				Uint:   255,
+				String: "A",
+			},
+		},
+		{
+			desc: "error - overflow",
+			m: map[string]any{
+				"Int8": int64(300),
+			},
+			dst: &struct {
+				Int8 int8
+			}{},
+			err: mapx.ErrOverflow,
+		},
+		{
+			desc: "error - uint overflow",
+			m: map[string]any{
+				"Int": uint64(1 << 63),
+			},
+			dst: &struct {
+				Int int64
+			}{},
+			err: mapx.ErrOverflow,
+		},
+		{
+			desc: "error - float truncation",
+			m: map[string]any{
+				"Int": 1.7,
+			},
+			dst: &struct {
+				Int int
+			}{},
+			err: mapx.ErrPrecisionLoss,
+		},
+		{
+			desc: "error - int to float precision",
+			m: map[string]any{
+				"Float": 1<<24 + 1,
+			},
+			dst: &struct {
+				Float float32
+			}{},
+			err: mapx.ErrPrecisionLoss,
+		},
+		{
+			desc: "error - negative to unsigned",
+			m: map[string]any{
+				"Uint": -1.0,
+			},
+			dst: &struct {
+				Uint uint
+			}{},
+			err: mapx.ErrNegativeUnsigned,
+		},
+		{
+			desc: "error - int to string",
+			m: map[string]any{
+				"String": 65,
+			},
+			dst: &struct {
+				String string
+			}{},
+			err: mapx.ErrRuneConversion,
+		},
+		{
+			desc: "error - conversion not allowed",
+			m: map[string]any{
+				"Int":   int8(1),
+				"Float": 1.0,
+			},
+			opts: mapx.DecoderOpt{
+				Conversions: mapx.ConversionPolicy{
+					Allowed: []mapx.Conversion{
+						{From: reflect.Int8, To: reflect.Int},
+					},
+				},
+			},
+			dst: &struct {
+				Int   int
+				Float int
+			}{},
+			err: mapx.ErrConversionNotAllowed,
+		},
+		{
+			desc: "error - map key overflow",
+			m: map[string]any{
+				"Map": map[int]int{300: 1},
+			},
+			dst: &struct {
+				Map map[int8]int
+			}{},
+			err: mapx.ErrOverflow,
+		},
+		{
+			desc: "error - map key",
+			m: map[string]any{
+				"ByID": map[string]any{"x": 1},
+			},
+			dst: &struct {
+				ByID map[int]int
+			}{},
+			err: &mapx.DecodeError{
+				Value: "x",
+				Type:  reflect.TypeOf(0),
+			},
+		},
+		{
+			desc: "error - map value",
+			m: map[string]any{
+				"ByID": map[string]any{"1": "x"},
+			},
+			dst: &struct {
+				ByID map[int]int
+			}{},
+			err: &mapx.DecodeError{
+				Value: "x",
+				Type:  reflect.TypeOf(0),
+			},
+		},
 		{
 			desc: "error - string to int",
 			m: map[string]any{
@@ -515,3 +967,1260 @@ func TestDecodeTypedD(t *testing.T) {
 }
 
 func ptr[T any](v T) *T { return &v }
+
+func TestDecodeErrorPath(t *testing.T) {
+	type Item struct {
+		Price int `mapx:"price"`
+	}
+
+	type Order struct {
+		Address Address          `mapx:"address"`
+		Items   []Item           `mapx:"items"`
+		Limits  map[string]Limit `mapx:"limits"`
+		B       B                `mapx:"b_,inline"`
+	}
+
+	fixtures := []struct {
+		desc  string
+		m     map[string]any
+		key   string
+		field string
+	}{
+		{
+			desc:  "nested struct",
+			m:     map[string]any{"address": map[string]any{"Unit": "x"}},
+			key:   "address.Unit",
+			field: "Address.Unit",
+		},
+		{
+			desc: "slice element",
+			m: map[string]any{"items": []any{
+				map[string]any{"price": 1},
+				map[string]any{"price": "x"},
+			}},
+			key:   "items[1].price",
+			field: "Items[1].Price",
+		},
+		{
+			desc:  "map value",
+			m:     map[string]any{"limits": map[string]any{"cpu": map[string]any{"max": "x"}}},
+			key:   "limits.cpu.max",
+			field: "Limits[cpu].Max",
+		},
+		{
+			desc:  "slice value",
+			m:     map[string]any{"b_Ints": []any{1, "x"}},
+			key:   "b_Ints[1]",
+			field: "B.Ints[1]",
+		},
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.desc, func(t *testing.T) {
+			var o Order
+			err := mapx.Decode(f.m, &o)
+
+			var derr *mapx.DecodeError
+			if !errors.As(err, &derr) {
+				t.Fatalf("expected DecodeError; got %v", err)
+			}
+
+			if derr.Key != f.key {
+				t.Errorf("want key=%q; got %q", f.key, derr.Key)
+			}
+
+			if derr.Field != f.field {
+				t.Errorf("want field=%q; got %q", f.field, derr.Field)
+			}
+		})
+	}
+}
+
+func TestDecodeAllErrors(t *testing.T) {
+	errCustom := errors.New("custom error")
+
+	dec := mapx.NewDecoder[*D](mapx.DecoderOpt{
+		AllErrors: true,
+		DecoderFuncs: mapx.RegisterDecoder(mapx.DecoderFuncs{}, func(s string, dst *Int) error {
+			return errCustom
+		}),
+	})
+
+	m := map[string]any{
+		"C":    map[string]any{"A": "1", "Ptr": "x"},
+		"Ints": []any{1, "x", 3, nil},
+		"CS": []any{
+			map[string]any{"A": 1},
+			map[string]any{"A": 2, "V": 10},
+		},
+	}
+
+	var d D
+	err := dec.Decode(m, &d)
+
+	var errs mapx.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors; got %v", err)
+	}
+
+	var keys []string
+	for _, err := range errs {
+		var derr *mapx.DecodeError
+		if !errors.As(err, &derr) {
+			t.Fatalf("expected DecodeError; got %v", err)
+		}
+		keys = append(keys, derr.Key)
+	}
+
+	sort.Strings(keys)
+	if d := cmp.Diff([]string{"C.A", "C.Ptr", "Ints[1]", "Ints[3]"}, keys); d != "" {
+		t.Error(d)
+	}
+
+	if !errors.Is(err, errCustom) {
+		t.Errorf("expected %v to be found", errCustom)
+	}
+
+	// valid values are still decoded.
+	if d := cmp.Diff([]C{{A: 1}, {A: 2, V: 10}}, d.CS); d != "" {
+		t.Error(d)
+	}
+}
+
+func TestDecodeDisallowUnknownKeys(t *testing.T) {
+	type Order struct {
+		Address Address   `mapx:"address"`
+		Items   []Address `mapx:"items"`
+		Note    string    `mapx:"note"`
+	}
+
+	fixtures := []struct {
+		desc     string
+		m        map[string]any
+		expected []mapx.UnknownKeyError
+	}{
+		{
+			desc: "no unknown keys",
+			m: map[string]any{
+				"address": map[string]any{"street": "Main St"},
+				"items":   []any{map[string]any{"Unit": 1}},
+			},
+		},
+		{
+			desc: "top level",
+			m: map[string]any{
+				"adress": map[string]any{},
+				"foo":    1,
+			},
+			expected: []mapx.UnknownKeyError{
+				{Key: "adress", Suggestion: "address"},
+				{Key: "foo"},
+			},
+		},
+		{
+			desc: "nested struct",
+			m: map[string]any{
+				"address": map[string]any{"stret": "Main St"},
+			},
+			expected: []mapx.UnknownKeyError{
+				{Key: "address.stret", Suggestion: "street"},
+			},
+		},
+		{
+			desc: "slice element",
+			m: map[string]any{
+				"items": []any{
+					map[string]any{"Unit": 1},
+					map[string]any{"unit": 1},
+				},
+			},
+			expected: []mapx.UnknownKeyError{
+				{Key: "items[1].unit", Suggestion: "Unit"},
+			},
+		},
+	}
+
+	dec := mapx.NewDecoder[*Order](mapx.DecoderOpt{
+		DisallowUnknownKeys: true,
+		AllErrors:           true,
+	})
+
+	for _, f := range fixtures {
+		t.Run(f.desc, func(t *testing.T) {
+			var o Order
+			err := dec.Decode(f.m, &o)
+			if f.expected == nil {
+				if err != nil {
+					t.Fatal("expected err=nil; got ", err)
+				}
+				return
+			}
+
+			var errs mapx.Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected Errors; got %v", err)
+			}
+
+			var out []mapx.UnknownKeyError
+			for _, err := range errs {
+				var uerr *mapx.UnknownKeyError
+				if !errors.As(err, &uerr) {
+					t.Fatalf("expected UnknownKeyError; got %v", err)
+				}
+				out = append(out, *uerr)
+			}
+
+			if d := cmp.Diff(f.expected, out); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestDecodeRequired(t *testing.T) {
+	type Inner struct {
+		ID   int    `mapx:"id,required"`
+		Name string `mapx:"name"`
+	}
+
+	type Embedded struct {
+		Version int `mapx:"version,required"`
+	}
+
+	type Outer struct {
+		Embedded
+		Inner  Inner   `mapx:"inner"`
+		Inline Inner   `mapx:"inline_,inline"`
+		Items  []Inner `mapx:"items"`
+		Ptr    *int    `mapx:"ptr,required"`
+	}
+
+	fixtures := []struct {
+		desc     string
+		m        map[string]any
+		opts     mapx.DecoderOpt
+		expected []mapx.RequiredKeyError
+	}{
+		{
+			desc: "all present",
+			m: map[string]any{
+				"version":   1,
+				"inner":     map[string]any{"id": 1},
+				"inline_id": 2,
+				"items":     []any{map[string]any{"id": 3}},
+				"ptr":       nil,
+			},
+		},
+		{
+			desc: "missing",
+			m: map[string]any{
+				"inner": map[string]any{"name": "foo"},
+				"items": []any{
+					map[string]any{"id": 1},
+					map[string]any{},
+				},
+			},
+			expected: []mapx.RequiredKeyError{
+				{Key: "version", Field: "Version"},
+				{Key: "inner.id", Field: "Inner.ID"},
+				{Key: "inline_id", Field: "Inline.ID"},
+				{Key: "items[1].id", Field: "Items[1].ID"},
+				{Key: "ptr", Field: "Ptr"},
+			},
+		},
+		{
+			desc: "nil",
+			m: map[string]any{
+				"version":   nil,
+				"inner":     map[string]any{"id": nil},
+				"inline_id": 1,
+				"ptr":       nil,
+			},
+			expected: []mapx.RequiredKeyError{
+				{Key: "version", Field: "Version"},
+				{Key: "inner.id", Field: "Inner.ID"},
+			},
+		},
+		{
+			desc: "nil - zero policy",
+			m: map[string]any{
+				"version":   nil,
+				"inner":     map[string]any{"id": 1},
+				"inline_id": 1,
+				"ptr":       nil,
+			},
+			opts: mapx.DecoderOpt{NilPolicy: mapx.DecodeNilZero},
+			expected: []mapx.RequiredKeyError{
+				{Key: "version", Field: "Version"},
+			},
+		},
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.desc, func(t *testing.T) {
+			f.opts.AllErrors = true
+			dec := mapx.NewDecoder[*Outer](f.opts)
+
+			var o Outer
+			err := dec.Decode(f.m, &o)
+			if f.expected == nil {
+				if err != nil {
+					t.Fatal("expected err=nil; got ", err)
+				}
+				return
+			}
+
+			var errs mapx.Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected Errors; got %v", err)
+			}
+
+			var out []mapx.RequiredKeyError
+			for _, err := range errs {
+				var rerr *mapx.RequiredKeyError
+				if !errors.As(err, &rerr) {
+					t.Fatalf("expected RequiredKeyError; got %v", err)
+				}
+				out = append(out, *rerr)
+			}
+
+			if d := cmp.Diff(f.expected, out); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+type ServerConfig struct {
+	Host    string        `mapx:"host,default=localhost"`
+	Port    int           `mapx:"port,default=8080"`
+	Timeout time.Duration `mapx:"timeout,default=30s"`
+	Tags    []string      `mapx:"tags,default=a,b"`
+	Ratio   *float64      `mapx:"ratio,default=0.5"`
+	Empty   []int         `mapx:"empty,default="`
+}
+
+type AppConfig struct {
+	Name    string         `mapx:"name"`
+	Retries int            `mapx:"retries"`
+	Server  ServerConfig   `mapx:"server"`
+	Backup  *ServerConfig  `mapx:"backup"`
+	Workers []ServerConfig `mapx:"workers"`
+}
+
+func (c *AppConfig) SetDefaults() {
+	c.Name = "app"
+	c.Retries = 3
+}
+
+func TestDecodeDefaults(t *testing.T) {
+	defaultServer := ServerConfig{
+		Host:    "localhost",
+		Port:    8080,
+		Timeout: 30 * time.Second,
+		Tags:    []string{"a", "b"},
+		Ratio:   ptr(0.5),
+		Empty:   []int{},
+	}
+
+	withPort := func(port int) ServerConfig {
+		c := defaultServer
+		c.Port = port
+		return c
+	}
+
+	fixtures := []struct {
+		desc     string
+		m        map[string]any
+		expected AppConfig
+	}{
+		{
+			desc: "empty input",
+			m:    map[string]any{},
+			expected: AppConfig{
+				Name:    "app",
+				Retries: 3,
+				Server:  defaultServer,
+			},
+		},
+		{
+			desc: "input overrides defaults",
+			m: map[string]any{
+				"name":    "svc",
+				"server":  map[string]any{"port": 80},
+				"backup":  map[string]any{},
+				"workers": []any{map[string]any{"port": 1}},
+			},
+			expected: AppConfig{
+				Name:    "svc",
+				Retries: 3,
+				Server:  withPort(80),
+				Backup:  &defaultServer,
+				Workers: []ServerConfig{withPort(1)},
+			},
+		},
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.desc, func(t *testing.T) {
+			var c AppConfig
+			if err := mapx.Decode(f.m, &c); err != nil {
+				t.Fatal("expected err=nil; got ", err)
+			}
+
+			if d := cmp.Diff(f.expected, c); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+
+	t.Run("decode twice", func(t *testing.T) {
+		var c AppConfig
+		if err := mapx.Decode(map[string]any{"server": map[string]any{"port": 9000}}, &c); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		c.Retries = 5
+		if err := mapx.Decode(map[string]any{"server": map[string]any{"host": "h"}}, &c); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		expected := AppConfig{
+			Name:    "app",
+			Retries: 5,
+			Server:  withPort(9000),
+		}
+		expected.Server.Host = "h"
+
+		if d := cmp.Diff(expected, c); d != "" {
+			t.Error(d)
+		}
+	})
+
+	t.Run("options after default", func(t *testing.T) {
+		var dst struct {
+			Port int      `mapx:"port,default=8080,required"`
+			Tags []string `mapx:"tags,default=a,b,omitempty"`
+		}
+
+		err := mapx.Decode(map[string]any{}, &dst)
+
+		var rerr *mapx.RequiredKeyError
+		if !errors.As(err, &rerr) || rerr.Key != "port" {
+			t.Fatalf("expected RequiredKeyError for key port; got %v", err)
+		}
+
+		if err := mapx.Decode(map[string]any{"port": 1}, &dst); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if d := cmp.Diff([]string{"a", "b"}, dst.Tags); d != "" {
+			t.Error(d)
+		}
+	})
+
+	t.Run("allowed conversions", func(t *testing.T) {
+		type Cfg struct {
+			Port int `mapx:"port,default=8080"`
+			N    int `mapx:"n"`
+		}
+
+		var dst Cfg
+		dec := mapx.NewDecoder[*Cfg](mapx.DecoderOpt{
+			Conversions: mapx.ConversionPolicy{
+				Allowed: []mapx.Conversion{{From: reflect.Int64, To: reflect.Int}},
+			},
+		})
+
+		if err := dec.Decode(map[string]any{}, &dst); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if dst.Port != 8080 {
+			t.Errorf("want port=8080; got %d", dst.Port)
+		}
+
+		// input is still restricted.
+		err := dec.Decode(map[string]any{"n": "1"}, &dst)
+		if !errors.Is(err, mapx.ErrConversionNotAllowed) {
+			t.Errorf("expected %v; got %v", mapx.ErrConversionNotAllowed, err)
+		}
+	})
+
+	t.Run("invalid default", func(t *testing.T) {
+		var dst struct {
+			N int `mapx:"n,default=x"`
+		}
+
+		err := mapx.Decode(map[string]any{}, &dst)
+
+		var derr *mapx.DecodeError
+		if !errors.As(err, &derr) || derr.Key != "n" {
+			t.Errorf("expected DecodeError for key n; got %v", err)
+		}
+	})
+}
+
+func TestDecodeNormalizeKey(t *testing.T) {
+	type Inner struct {
+		StreetName string `mapx:"street_name"`
+	}
+
+	type S struct {
+		Name  string
+		Inner Inner
+	}
+
+	ignoreSeparators := mapx.KeyNormalizer(func(k string) string {
+		return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(k))
+	})
+
+	fixtures := []struct {
+		desc      string
+		normalize mapx.KeyNormalizer
+		m         map[string]any
+		expected  S
+		err       error
+	}{
+		{
+			desc:      "case insensitive",
+			normalize: mapx.CaseInsensitive,
+			m: map[string]any{
+				"NAME":  "foo",
+				"inner": map[string]any{"Street_Name": "bar"},
+			},
+			expected: S{Name: "foo", Inner: Inner{StreetName: "bar"}},
+		},
+		{
+			desc:      "custom",
+			normalize: ignoreSeparators,
+			m: map[string]any{
+				"name":  "foo",
+				"Inner": map[string]any{"street-name": "bar"},
+			},
+			expected: S{Name: "foo", Inner: Inner{StreetName: "bar"}},
+		},
+		{
+			desc: "exact",
+			m: map[string]any{
+				"name": "foo",
+			},
+			err: &mapx.UnknownKeyError{
+				Key:        "name",
+				Suggestion: "Name",
+			},
+		},
+		{
+			desc:      "ambiguous",
+			normalize: mapx.CaseInsensitive,
+			m: map[string]any{
+				"name": "foo",
+				"NAME": "bar",
+				"Name": "baz",
+			},
+			err: &mapx.AmbiguousKeyError{
+				Key:  "Name",
+				Keys: []string{"NAME", "Name", "name"},
+			},
+		},
+		{
+			desc:      "unknown keys",
+			normalize: mapx.CaseInsensitive,
+			m: map[string]any{
+				"name":  "foo",
+				"inner": map[string]any{"STREET_NAME": "bar", "street": "x"},
+			},
+			err: &mapx.UnknownKeyError{
+				Key: "inner.street",
+			},
+		},
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.desc, func(t *testing.T) {
+			dec := mapx.NewDecoder[*S](mapx.DecoderOpt{
+				NormalizeKey:        f.normalize,
+				DisallowUnknownKeys: true,
+			})
+
+			var dst S
+			err := dec.Decode(f.m, &dst)
+			if f.err != nil {
+				if d := cmp.Diff(f.err, err); d != "" {
+					t.Error(d)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal("expected err=nil; got ", err)
+			}
+
+			if d := cmp.Diff(f.expected, dst); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestDecodeNilPolicy(t *testing.T) {
+	type Inner struct {
+		N int
+	}
+
+	type Embedded struct {
+		E int
+	}
+
+	type T struct {
+		*Embedded
+		Int   int
+		Ptr   *int
+		Slice []int
+		Inner Inner
+		Ints  []int
+	}
+
+	one := 1
+	input := func() T {
+		return T{
+			Embedded: &Embedded{E: 1},
+			Int:      1,
+			Ptr:      &one,
+			Slice:    []int{1},
+			Inner:    Inner{N: 1},
+		}
+	}
+
+	m := map[string]any{
+		"E":     nil,
+		"Int":   nil,
+		"Ptr":   nil,
+		"Slice": nil,
+		"Inner": map[string]any{"N": nil},
+		"Ints":  []any{1, nil},
+	}
+
+	fixtures := []struct {
+		desc     string
+		policy   mapx.DecodeNilPolicy
+		expected T
+		keys     []string
+	}{
+		{
+			desc: "default",
+			keys: []string{"E", "Int", "Inner.N", "Ints[1]"},
+		},
+		{
+			desc:     "zero",
+			policy:   mapx.DecodeNilZero,
+			expected: T{Embedded: &Embedded{}, Ints: []int{1, 0}},
+		},
+		{
+			desc:     "keep",
+			policy:   mapx.DecodeNilKeep,
+			expected: T{Embedded: &Embedded{E: 1}, Int: 1, Ptr: &one, Slice: []int{1}, Inner: Inner{N: 1}, Ints: []int{1, 0}},
+		},
+		{
+			desc:   "error",
+			policy: mapx.DecodeNilError,
+			keys:   []string{"E", "Int", "Ptr", "Slice", "Inner.N", "Ints[1]"},
+		},
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.desc, func(t *testing.T) {
+			dec := mapx.NewDecoder[*T](mapx.DecoderOpt{
+				NilPolicy: f.policy,
+				AllErrors: true,
+			})
+
+			out := input()
+			err := dec.Decode(m, &out)
+			if f.keys == nil {
+				if err != nil {
+					t.Fatal("expected err=nil; got ", err)
+				}
+				if d := cmp.Diff(f.expected, out); d != "" {
+					t.Error(d)
+				}
+				return
+			}
+
+			var errs mapx.Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected Errors; got %v", err)
+			}
+
+			var keys []string
+			for _, err := range errs {
+				var derr *mapx.DecodeError
+				if !errors.As(err, &derr) {
+					t.Fatalf("expected DecodeError; got %v", err)
+				}
+				if got := errors.Is(err, mapx.ErrNilValue); got != (f.policy == mapx.DecodeNilError) {
+					t.Errorf("%s: unexpected errors.Is(err, ErrNilValue)=%v", derr.Key, got)
+				}
+				keys = append(keys, derr.Key)
+			}
+
+			if d := cmp.Diff(f.keys, keys); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+
+	t.Run("empty string as nil", func(t *testing.T) {
+		type T struct {
+			Ptr    *int
+			String string
+			Nested struct {
+				Ptr *string
+			}
+		}
+
+		dec := mapx.NewDecoder[*T](mapx.DecoderOpt{
+			EmptyStringAsNil: true,
+			NilPolicy:        mapx.DecodeNilZero,
+		})
+
+		s := "foo"
+		out := T{Ptr: &one, String: "foo"}
+		out.Nested.Ptr = &s
+
+		err := dec.Decode(map[string]any{
+			"Ptr":    "",
+			"String": "",
+			"Nested": map[string]any{"Ptr": ""},
+		}, &out)
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if out.Ptr != nil || out.Nested.Ptr != nil || out.String != "" {
+			t.Errorf("expected nil pointers and empty string; got %+v", out)
+		}
+	})
+}
+
+func TestRemain(t *testing.T) {
+	type Inner struct {
+		ID    int            `mapx:"id"`
+		Extra map[string]any `mapx:",remain"`
+	}
+
+	type Doc struct {
+		Name  string         `mapx:"name"`
+		Count int            `mapx:"count,omitempty"`
+		Inner Inner          `mapx:"inner"`
+		Extra map[string]any `mapx:",remain"`
+	}
+
+	in := map[string]any{
+		"name":  "foo",
+		"owner": "bar",
+		"tags":  []any{"a"},
+		"inner": map[string]any{"id": 1, "kind": "x"},
+	}
+
+	dec := mapx.NewDecoder[*Doc](mapx.DecoderOpt{DisallowUnknownKeys: true})
+
+	var doc Doc
+	if err := dec.Decode(in, &doc); err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	expected := Doc{
+		Name:  "foo",
+		Inner: Inner{ID: 1, Extra: map[string]any{"kind": "x"}},
+		Extra: map[string]any{"owner": "bar", "tags": []any{"a"}},
+	}
+
+	if d := cmp.Diff(expected, doc); d != "" {
+		t.Error(d)
+	}
+
+	m, err := mapx.Encode(doc)
+	if err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	if d := cmp.Diff(in, m); d != "" {
+		t.Error(d)
+	}
+
+	t.Run("fields take precedence", func(t *testing.T) {
+		doc := Doc{
+			Name: "foo",
+			Extra: map[string]any{
+				"name":  "bar",
+				"count": 1,
+				"other": 2,
+			},
+		}
+
+		m, err := mapx.Encode(doc)
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		expected := map[string]any{
+			"name":  "foo",
+			"inner": map[string]any{"id": 0},
+			"other": 2,
+		}
+
+		if d := cmp.Diff(expected, m); d != "" {
+			t.Error(d)
+		}
+	})
+
+	t.Run("normalized keys", func(t *testing.T) {
+		dec := mapx.NewDecoder[*Doc](mapx.DecoderOpt{NormalizeKey: mapx.CaseInsensitive})
+
+		var doc Doc
+		err := dec.Decode(map[string]any{"NAME": "foo", "Owner": "bar"}, &doc)
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if doc.Name != "foo" || !cmp.Equal(doc.Extra, map[string]any{"Owner": "bar"}) {
+			t.Errorf("unexpected result: %+v", doc)
+		}
+	})
+}
+
+type Shape interface {
+	Area() float64
+}
+
+type Circle struct {
+	R float64 `mapx:"r"`
+}
+
+func (c Circle) Area() float64 { return 3 * c.R * c.R }
+
+type Rect struct {
+	W float64 `mapx:"w"`
+	H float64 `mapx:"h"`
+}
+
+func (r *Rect) Area() float64 { return r.W * r.H }
+
+type Square struct {
+	A float64 `mapx:"a"`
+}
+
+func (s Square) Area() float64 { return s.A * s.A }
+
+var shapeTypes = mapx.RegisterType[Shape, *Rect](
+	mapx.RegisterType[Shape, Circle](mapx.Types{}, "type", "circle"),
+	"type", "rect",
+)
+
+func TestTypes(t *testing.T) {
+	type Drawing struct {
+		Shape  Shape            `mapx:"shape"`
+		Shapes []Shape          `mapx:"shapes"`
+		ByName map[string]Shape `mapx:"by_name"`
+		Nil    Shape            `mapx:"nil"`
+	}
+
+	in := Drawing{
+		Shape:  Circle{R: 1},
+		Shapes: []Shape{&Rect{W: 1, H: 2}, Circle{R: 2}},
+		ByName: map[string]Shape{"a": Circle{R: 3}},
+	}
+
+	enc := mapx.NewEncoder[Drawing](mapx.EncoderOpt{Types: shapeTypes})
+
+	m, err := enc.Encode(in)
+	if err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	expected := map[string]any{
+		"shape": map[string]any{"type": "circle", "r": 1.0},
+		"shapes": []any{
+			map[string]any{"type": "rect", "w": 1.0, "h": 2.0},
+			map[string]any{"type": "circle", "r": 2.0},
+		},
+		"by_name": map[string]any{"a": map[string]any{"type": "circle", "r": 3.0}},
+		"nil":     nil,
+	}
+
+	if d := cmp.Diff(expected, m); d != "" {
+		t.Error(d)
+	}
+
+	dec := mapx.NewDecoder[*Drawing](mapx.DecoderOpt{
+		Types:               shapeTypes,
+		DisallowUnknownKeys: true,
+	})
+
+	var out Drawing
+	if err := dec.Decode(m, &out); err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	if d := cmp.Diff(in, out); d != "" {
+		t.Error(d)
+	}
+
+	t.Run("unknown type", func(t *testing.T) {
+		var out Drawing
+		err := dec.Decode(map[string]any{
+			"shapes": []any{map[string]any{"type": "hexagon"}},
+		}, &out)
+
+		var derr *mapx.DecodeError
+		if !errors.As(err, &derr) || !errors.Is(err, mapx.ErrUnknownType) {
+			t.Fatalf("expected DecodeError with %v; got %v", mapx.ErrUnknownType, err)
+		}
+
+		if derr.Key != "shapes[0]" {
+			t.Errorf("want key=shapes[0]; got %s", derr.Key)
+		}
+	})
+
+	t.Run("unregistered type", func(t *testing.T) {
+		_, err := enc.Encode(Drawing{Shape: Square{A: 1}})
+		if !errors.Is(err, mapx.ErrUnknownType) {
+			t.Errorf("expected %v; got %v", mapx.ErrUnknownType, err)
+		}
+	})
+}
+
+func TestDecodeIntoInterfaces(t *testing.T) {
+	type PluginConfig struct {
+		URL     string `mapx:"url"`
+		Retries int    `mapx:"retries"`
+	}
+
+	type Plugin struct {
+		Name   string `mapx:"name"`
+		Config any    `mapx:"config"`
+		Level  any    `mapx:"level"`
+		Raw    any    `mapx:"raw"`
+	}
+
+	funcs := mapx.RegisterDecoder(mapx.DecoderFuncs{}, func(s string, l *Level) error {
+		return l.UnmarshalText([]byte(s))
+	})
+
+	m := map[string]any{
+		"name":   "http",
+		"config": map[string]any{"url": "localhost"},
+		"level":  "high",
+		"raw":    map[string]any{"url": "localhost"},
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		cfg := &PluginConfig{Retries: 3}
+		lvl := new(Level)
+
+		p := Plugin{Config: cfg, Level: lvl, Raw: PluginConfig{}}
+
+		dec := mapx.NewDecoder[*Plugin](mapx.DecoderOpt{
+			DecoderFuncs:         funcs,
+			DecodeIntoInterfaces: true,
+		})
+
+		if err := dec.Decode(m, &p); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if p.Config != cfg || *cfg != (PluginConfig{URL: "localhost", Retries: 3}) {
+			t.Errorf("expected config to be decoded in place; got %+v", p.Config)
+		}
+
+		if p.Level != lvl || *lvl != LevelHigh {
+			t.Errorf("expected level to be decoded in place; got %v", p.Level)
+		}
+
+		// values that are not pointers are replaced.
+		if d := cmp.Diff(m["raw"], p.Raw); d != "" {
+			t.Error(d)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		p := Plugin{Config: &PluginConfig{}}
+
+		if err := mapx.Decode(m, &p); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if d := cmp.Diff(m["config"], p.Config); d != "" {
+			t.Error(d)
+		}
+	})
+}
+
+func TestDecodeMerge(t *testing.T) {
+	type Server struct {
+		Host string `mapx:"host"`
+		Port int    `mapx:"port"`
+	}
+
+	type Config struct {
+		Name   string            `mapx:"name"`
+		Tags   []string          `mapx:"tags"`
+		Labels map[string]string `mapx:"labels"`
+		Limits map[string]Limit  `mapx:"limits"`
+		Server *Server           `mapx:"server"`
+	}
+
+	base := func() (Config, *Server) {
+		server := &Server{Host: "localhost", Port: 80}
+		return Config{
+			Name:   "base",
+			Tags:   []string{"a"},
+			Labels: map[string]string{"env": "dev", "team": "x"},
+			Limits: map[string]Limit{"cpu": {Max: 1}},
+			Server: server,
+		}, server
+	}
+
+	patch := map[string]any{
+		"tags":   []any{"b"},
+		"labels": map[string]any{"env": "prod"},
+		"limits": map[string]any{"cpu": map[string]any{}, "mem": map[string]any{"max": 2}},
+		"server": map[string]any{"port": 8080},
+	}
+
+	fixtures := []struct {
+		desc     string
+		policy   mapx.MergePolicy
+		expected Config
+		reused   bool
+	}{
+		{
+			desc: "default",
+			expected: Config{
+				Name:   "base",
+				Tags:   []string{"b"},
+				Labels: map[string]string{"env": "prod"},
+				Limits: map[string]Limit{"cpu": {}, "mem": {Max: 2}},
+				Server: &Server{Host: "localhost", Port: 8080},
+			},
+			reused: true,
+		},
+		{
+			desc: "merge",
+			policy: mapx.MergePolicy{
+				AppendSlices: true,
+				MergeMaps:    true,
+			},
+			expected: Config{
+				Name:   "base",
+				Tags:   []string{"a", "b"},
+				Labels: map[string]string{"env": "prod", "team": "x"},
+				Limits: map[string]Limit{"cpu": {Max: 1}, "mem": {Max: 2}},
+				Server: &Server{Host: "localhost", Port: 8080},
+			},
+			reused: true,
+		},
+		{
+			desc:   "realloc pointers",
+			policy: mapx.MergePolicy{ReallocPointers: true},
+			expected: Config{
+				Name:   "base",
+				Tags:   []string{"b"},
+				Labels: map[string]string{"env": "prod"},
+				Limits: map[string]Limit{"cpu": {}, "mem": {Max: 2}},
+				Server: &Server{Port: 8080},
+			},
+		},
+		{
+			desc:   "zero first",
+			policy: mapx.MergePolicy{ZeroFirst: true, MergeMaps: true},
+			expected: Config{
+				Tags:   []string{"b"},
+				Labels: map[string]string{"env": "prod"},
+				Limits: map[string]Limit{"cpu": {}, "mem": {Max: 2}},
+				Server: &Server{Port: 8080},
+			},
+		},
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.desc, func(t *testing.T) {
+			dec := mapx.NewDecoder[*Config](mapx.DecoderOpt{Merge: f.policy})
+
+			cfg, server := base()
+			if err := dec.Decode(patch, &cfg); err != nil {
+				t.Fatal("expected err=nil; got ", err)
+			}
+
+			if d := cmp.Diff(f.expected, cfg); d != "" {
+				t.Error(d)
+			}
+
+			if reused := cfg.Server == server; reused != f.reused {
+				t.Errorf("want reused=%v; got %v", f.reused, reused)
+			}
+		})
+	}
+}
+
+func TestDecodeMetadata(t *testing.T) {
+	type Item struct {
+		Price int `mapx:"price"`
+		Qty   int `mapx:"qty"`
+	}
+
+	type Order struct {
+		Enabled bool   `mapx:"enabled"`
+		Name    string `mapx:"name,default=none"`
+		Items   []Item `mapx:"items"`
+		Note    string `mapx:"note"`
+	}
+
+	dec := mapx.NewDecoder[*Order](mapx.DecoderOpt{})
+
+	var o Order
+	md, err := dec.DecodeMetadata(map[string]any{
+		"enabled": false,
+		"items": []any{
+			map[string]any{"price": 1, "color": "red"},
+		},
+		"extra": 1,
+	}, &o)
+	if err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	expected := mapx.Metadata{
+		Set:       []string{"Enabled", "Items", "Items[0].Price"},
+		Keys:      []string{"enabled", "items", "items[0].price"},
+		Unused:    []string{"extra", "items[0].color"},
+		Untouched: []string{"Items[0].Qty", "Name", "Note"},
+	}
+
+	if d := cmp.Diff(expected, md); d != "" {
+		t.Error(d)
+	}
+
+	if o.Name != "none" {
+		t.Errorf("expected default to be set; got %q", o.Name)
+	}
+
+	t.Run("remain", func(t *testing.T) {
+		type Doc struct {
+			Name  string         `mapx:"name"`
+			Extra map[string]any `mapx:",remain"`
+		}
+
+		var d Doc
+		md, err := mapx.NewDecoder[*Doc](mapx.DecoderOpt{}).DecodeMetadata(map[string]any{"name": "foo", "owner": "bar"}, &d)
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		expected := mapx.Metadata{
+			Set:  []string{"Extra", "Name"},
+			Keys: []string{"name", "owner"},
+		}
+
+		if diff := cmp.Diff(expected, md); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("failed field", func(t *testing.T) {
+		type Doc struct {
+			A int
+			B int
+		}
+
+		var d Doc
+		md, err := mapx.NewDecoder[*Doc](mapx.DecoderOpt{AllErrors: true}).DecodeMetadata(map[string]any{"A": "x", "B": 1}, &d)
+		if err == nil {
+			t.Fatal("expected err for field A")
+		}
+
+		expected := mapx.Metadata{
+			Set:  []string{"B"},
+			Keys: []string{"B"},
+		}
+
+		if diff := cmp.Diff(expected, md); diff != "" {
+			t.Error(diff)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	type Item struct {
+		ID    int `mapx:"id,required"`
+		Price int `mapx:"price"`
+	}
+
+	type Payload struct {
+		Event string `mapx:"event,required"`
+		Count int    `mapx:"count"`
+		Items []Item `mapx:"items"`
+	}
+
+	m := map[string]any{
+		"count": "ten",
+		"items": []any{
+			map[string]any{"id": 1, "price": 1.5},
+			map[string]any{"prise": 2},
+		},
+		"evnt": "created",
+	}
+
+	err := mapx.Validate[Payload](m, mapx.DecoderOpt{DisallowUnknownKeys: true})
+
+	var errs mapx.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors; got %v", err)
+	}
+
+	var out []string
+	for _, err := range errs {
+		var (
+			derr *mapx.DecodeError
+			uerr *mapx.UnknownKeyError
+			rerr *mapx.RequiredKeyError
+		)
+
+		switch {
+		case errors.As(err, &derr):
+			out = append(out, "type: "+derr.Key)
+		case errors.As(err, &uerr):
+			out = append(out, "unknown: "+uerr.Key)
+		case errors.As(err, &rerr):
+			out = append(out, "required: "+rerr.Key)
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := []string{
+		"unknown: evnt",
+		"required: event",
+		"type: count",
+		"type: items[0].price",
+		"unknown: items[1].prise",
+		"required: items[1].id",
+	}
+
+	if d := cmp.Diff(expected, out); d != "" {
+		t.Error(d)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		dec := mapx.NewDecoder[*Payload](mapx.DecoderOpt{})
+
+		err := dec.Validate(map[string]any{
+			"event": "created",
+			"items": []any{map[string]any{"id": 1}},
+		})
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+	})
+}
@@ -1,8 +1,12 @@
 package mapx
 
 import (
+	"encoding"
 	"errors"
+	"fmt"
 	"reflect"
+	"strconv"
+	"sync"
 )
 
 var defaultEncoder = NewEncoder[any](EncoderOpt{})
@@ -10,113 +14,501 @@ var defaultEncoder = NewEncoder[any](EncoderOpt{})
 type EncoderOpt struct {
 	EncoderFuncs EncoderFuncs
 	Tag          string
+
+	// KeyNaming converts names of untagged fields into keys, e.g. SnakeCase.
+	KeyNaming *KeyNaming
+
+	// OmitEmpty applies omitempty tag option to all fields.
+	OmitEmpty bool
+
+	// NilPolicy defines how nil fields are encoded, including fields promoted
+	// through nil embedded pointers.
+	NilPolicy EncodeNilPolicy
+
+	// StdInterfaces selects standard library interfaces used to encode values,
+	// e.g. TextInterfaces encodes time.Time into RFC 3339 strings.
+	StdInterfaces StdInterfaces
+
+	// Types holds concrete types of interfaces. Values held by such interfaces
+	// are encoded with their discriminator.
+	Types Types
+
+	// References makes the encoder write structs that are pointed to more than
+	// once only the first time. They get an id under RefIDKey and other
+	// occurrences are encoded as maps with a single RefKey. Otherwise pointer
+	// cycles are reported with ErrCycle.
+	References bool
+}
+
+type EncodeError struct {
+	// Key is the path of map keys leading to the value, e.g. "items[3].price".
+	Key string
+	// Field is the path of Go struct fields the key maps to, e.g.
+	// "Items[3].Price".
+	Field string
+	Type  reflect.Type
+	Err   error
+}
+
+func newEncodeError(p keyPath, typ reflect.Type, err error) *EncodeError {
+	return &EncodeError{
+		Key:   p.keys(),
+		Field: p.fields(),
+		Type:  typ,
+		Err:   err,
+	}
+}
+
+// Error implements error interface.
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("mapx: cannot encode value of type %s (key %q, field %s): %v", e.Type, e.Key, e.Field, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *EncodeError) Unwrap() error {
+	return e.Err
 }
 
 type Encoder[T any] struct {
 	opts   EncoderOpt
 	fields fields
+
+	// convTypes caches results of convertible.
+	convTypes sync.Map
 }
 
 func NewEncoder[T any](opts EncoderOpt) *Encoder[T] {
 	return &Encoder[T]{
 		opts:   opts,
-		fields: structFields[T](opts.Tag),
+		fields: structFields[T](opts.Tag, opts.KeyNaming),
 	}
 }
 
 func (e *Encoder[T]) Encode(val T) (map[string]any, error) {
-	return e.encode(reflect.ValueOf(val), e.fields)
+	var s encodeState
+	if e.opts.References {
+		s.refs = make(map[visit]*reference)
+	}
+
+	v := reflect.ValueOf(val)
+	if !v.IsValid() {
+		return nil, ErrNotAStruct
+	}
+
+	if ok, out, err := marshal(nil, v); ok {
+		m, _ := out.(map[string]any)
+		return m, err
+	}
+
+	if v.Kind() == reflect.Pointer {
+		// the root can be referred to, but it's always encoded.
+		if _, err := s.enter(nil, v); err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := e.encode(&s, make(keyPath, 0, 8), v, e.fields)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Kind() == reflect.Pointer {
+		s.leave(v, m)
+	}
+	return m, nil
 }
 
-func (e *Encoder[T]) encode(v reflect.Value, fields fields) (_ map[string]any, err error) {
+func (e *Encoder[T]) encode(s *encodeState, p keyPath, v reflect.Value, fields fields) (_ map[string]any, err error) {
 	if v.Kind() == reflect.Pointer {
 		v = v.Elem()
 	}
 
 	if v.Kind() != reflect.Struct {
-		return nil, errors.New("not a struct")
+		return nil, ErrNotAStruct
 	}
 
 	if fields == nil {
 		fields = cachedFields(typeKey{
-			tag:  defaultTag(e.opts.Tag),
-			Type: v.Type(),
+			tag:    defaultTag(e.opts.Tag),
+			naming: e.opts.KeyNaming,
+			Type:   v.Type(),
 		})
 	}
 
 	m := make(map[string]any, len(fields))
-loop:
 	for _, f := range fields {
+		if f.tag.remain {
+			continue
+		}
+
 		fv := fieldByIndex(v, f.index, false)
+		if e.omitted(f, fv) {
+			continue
+		}
+
+		fp := append(p, fieldElem(f))
+
+		if isNilValue(fv) {
+			switch e.opts.NilPolicy {
+			case EncodeNilOmit:
+				continue
+			case EncodeNilError:
+				return nil, newEncodeError(fp, f.baseType, ErrNilValue)
+			}
+		}
+
 		if !fv.IsValid() {
 			m[f.name] = nil
 			continue
 		}
 
-		dst := fv.Interface()
+		out, skip, err := e.encodeElem(s, fp, fv, f.nested.fields(), f.tag.raw)
+		if err != nil {
+			return nil, err
+		}
 
-		if e.opts.EncoderFuncs.m != nil {
-			if fn, ok := e.opts.EncoderFuncs.m[f.baseType]; ok {
-				m[f.name], err = fn(fv.Interface())
-				if err != nil {
-					return nil, err
-				}
-				continue
+		if !skip {
+			m[f.name] = out
+		}
+	}
+
+	if f, ok := fields.remain(); ok {
+		e.encodeRemain(m, v, f, fields)
+	}
+	return m, nil
+}
+
+// encodeRemain merges the entries of the remain field f into m. Keys of other
+// fields take precedence, even if they are omitted.
+func (e *Encoder[T]) encodeRemain(m map[string]any, v reflect.Value, f field, fields fields) {
+	fv := fieldByIndex(v, f.index, false)
+	if !fv.IsValid() {
+		return
+	}
+
+	for iter := fv.MapRange(); iter.Next(); {
+		k := iter.Key().String()
+		if _, ok := m[k]; ok || fields.has(k) {
+			continue
+		}
+		m[k] = iter.Value().Interface()
+	}
+}
+
+// encodeCustom encodes v with registered funcs, Marshaler or standard
+// interfaces, in this order. Marshaler is not used for raw values. It reports
+// whether v was encoded.
+func (e *Encoder[T]) encodeCustom(p keyPath, v reflect.Value, raw bool) (bool, any, error) {
+	if ok, out, err := e.encodeFunc(p, v); ok {
+		return true, out, err
+	}
+
+	if !raw {
+		if ok, out, err := marshal(p, v); ok {
+			return true, out, err
+		}
+	}
+
+	return e.opts.StdInterfaces.encode(p, v)
+}
+
+// encodeFunc runs a registered encoder func for v. It reports whether any func
+// was found.
+func (e *Encoder[T]) encodeFunc(p keyPath, v reflect.Value) (_ bool, out any, err error) {
+	typ := v.Type()
+
+	if fn, ok := e.opts.EncoderFuncs.m[typ]; ok {
+		out, err = fn(v.Interface())
+		if err != nil {
+			return true, nil, newEncodeError(p, typ, err)
+		}
+		return true, out, nil
+	}
+
+	for _, fn := range e.opts.EncoderFuncs.ifaceFuncs {
+		switch {
+		case typ.Implements(fn.argType):
+			if (typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Interface) && v.IsNil() {
+				return true, nil, nil
 			}
+			out, err = fn.f(v.Interface())
+		case reflect.PointerTo(typ).Implements(fn.argType) && v.CanAddr():
+			out, err = fn.f(v.Addr().Interface())
+		default:
+			continue
+		}
+
+		if err != nil {
+			return true, nil, newEncodeError(p, typ, err)
+		}
+		return true, out, nil
+	}
+	return false, nil, nil
+}
+
+// convertible reports whether values of typ are changed by encodeValue: they
+// are structs, interfaces, marshalers, have registered encoder funcs, standard
+// interfaces or consist of such values.
+func (e *Encoder[T]) convertible(typ reflect.Type) bool {
+	if v, ok := e.convTypes.Load(typ); ok {
+		return v.(bool)
+	}
+
+	// protects from recursive types, e.g. type T []T.
+	e.convTypes.Store(typ, false)
+
+	var ok bool
+	switch {
+	case typ.Kind() == reflect.Struct && !isKnownStruct(typ):
+		ok = true
+	case typ.Kind() == reflect.Interface:
+		// the dynamic type is not known.
+		ok = true
+	case e.hasFunc(typ), isMarshaler(typ), e.opts.StdInterfaces.has(typ):
+		ok = true
+	case typ.Kind() == reflect.Slice, typ.Kind() == reflect.Array, typ.Kind() == reflect.Map:
+		// the func(any) encoder applies to elements of any type.
+		ok = e.opts.EncoderFuncs.anyConv != nil || e.convertible(typ.Elem())
+	case typ.Kind() == reflect.Pointer:
+		ok = e.convertible(typ.Elem())
+	}
+
+	e.convTypes.Store(typ, ok)
+	return ok
+}
+
+func (e *Encoder[T]) hasFunc(typ reflect.Type) bool {
+	if _, ok := e.opts.EncoderFuncs.m[typ]; ok {
+		return true
+	}
+
+	for _, fn := range e.opts.EncoderFuncs.ifaceFuncs {
+		if typ.Implements(fn.argType) || reflect.PointerTo(typ).Implements(fn.argType) {
+			return true
 		}
+	}
+	return false
+}
+
+// encodeElem encodes v, which is a struct field, a slice element or a map
+// value, using registered encoder funcs, including the func(any) one. It
+// reports whether v is skipped.
+func (e *Encoder[T]) encodeElem(s *encodeState, p keyPath, v reflect.Value, fields fields, raw bool) (any, bool, error) {
+	if ok, out, err := e.encodeCustom(p, v, raw); ok {
+		return out, false, err
+	}
+
+	if e.opts.EncoderFuncs.anyConv != nil {
+		out, err := e.opts.EncoderFuncs.anyConv(v.Interface())
+		if err != nil {
+			return nil, false, newEncodeError(p, v.Type(), err)
+		}
+
+		switch out {
+		case SkipValue{}:
+			return nil, true, nil
+		case NoChange{}:
+		default:
+			v, fields = reflect.ValueOf(out), nil
+		}
+	}
+
+	switch {
+	case !v.IsValid():
+		return nil, false, nil
+	case !raw && e.convertible(v.Type()):
+		out, err := e.encodeValue(s, p, v, fields)
+		return out, false, err
+	}
+	return v.Interface(), false, nil
+}
+
+// encodeValue encodes v using registered encoder funcs. Structs are encoded
+// into maps, interfaces are encoded by their dynamic type, and pointers,
+// slices, arrays and maps are walked if any of their elements are changed.
+// fields are the cached fields of the struct type v consists of, if any.
+func (e *Encoder[T]) encodeValue(s *encodeState, p keyPath, v reflect.Value, fields fields) (any, error) {
+	if ok, out, err := e.encodeCustom(p, v, false); ok {
+		return out, err
+	}
+
+	if !e.convertible(v.Type()) {
+		return v.Interface(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		if ref, err := s.enter(p, v); ref != nil || err != nil {
+			return ref, err
+		}
+
+		out, err := e.encodeValue(s, p, v.Elem(), fields)
+		if err != nil {
+			return nil, err
+		}
+
+		s.leave(v, out)
+		return out, nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		if ok, out, err := e.encodeType(s, p, v); ok {
+			return out, err
+		}
+
+		// fields are of the static type, the dynamic one can differ.
+		return e.encodeValue(s, p, v.Elem(), nil)
+	case reflect.Struct:
+		return e.encode(s, p, v, fields)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				return nil, nil
+			}
 
-		if e.opts.EncoderFuncs.ifaceFuncs != nil {
-			for _, fn := range e.opts.EncoderFuncs.ifaceFuncs {
-				if f.baseType.Implements(fn.argType) {
-					if f.baseType.Kind() == reflect.Pointer && fv.IsNil() {
-						m[f.name] = nil
-						continue loop
-					}
-					m[f.name], err = fn.f(fv.Interface())
-					if err != nil {
-						return nil, err
-					}
-					continue loop
-				}
-
-				if reflect.PointerTo(f.baseType).Implements(fn.argType) && fv.CanAddr() {
-					m[f.name], err = fn.f(fv.Addr().Interface())
-					if err != nil {
-						return nil, err
-					}
-					continue loop
-				}
+			if _, err := s.enter(p, v); err != nil {
+				return nil, err
 			}
+			defer s.leave(v, nil)
 		}
 
-		if e.opts.EncoderFuncs.anyConv != nil {
-			v, err := e.opts.EncoderFuncs.anyConv(dst)
+		out := make([]any, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elem, skip, err := e.encodeElem(s, append(p, indexElem(i)), v.Index(i), fields, false)
 			if err != nil {
 				return nil, err
 			}
 
-			switch v {
-			case SkipValue{}:
-				continue
-			case NoChange{}:
-			default:
-				dst = v
+			if !skip {
+				out = append(out, elem)
 			}
 		}
+		return out, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		if _, err := s.enter(p, v); err != nil {
+			return nil, err
+		}
+		defer s.leave(v, nil)
+
+		out := make(map[string]any, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			kp := append(p, mapKeyElem(iter.Key()))
+
+			k, err := encodeMapKey(iter.Key())
+			if err != nil {
+				return nil, newEncodeError(kp, iter.Key().Type(), err)
+			}
 
-		if f.typ.Kind() == reflect.Struct && !f.tag.raw {
-			sub, err := e.encode(fv, f.fields)
+			elem, skip, err := e.encodeElem(s, kp, iter.Value(), fields, false)
 			if err != nil {
 				return nil, err
 			}
-			m[f.name] = sub
-			continue
+
+			if !skip {
+				out[k] = elem
+			}
 		}
+		return out, nil
+	}
+	return v.Interface(), nil
+}
 
-		m[f.name] = dst
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// encodeMapKey converts a map key to string, so it can be decoded back by
+// decodeMapKey.
+func encodeMapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.Interface {
+		if k.IsNil() {
+			return "", errors.New("unsupported map key: nil")
+		}
+		k = k.Elem()
 	}
 
-	return m, nil
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+
+	if k.Type().Implements(textMarshalerType) {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", nil
+		}
+		text, err := k.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(k.Float(), 'g', -1, k.Type().Bits()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(k.Bool()), nil
+	}
+	return "", fmt.Errorf("unsupported map key type: %s", k.Type())
+}
+
+// omitted reports whether f should be omitted because of omitempty or omitzero
+// options. fv is invalid if f is promoted through a nil embedded pointer.
+func (e *Encoder[T]) omitted(f field, fv reflect.Value) bool {
+	omitEmpty := f.tag.omitEmpty || e.opts.OmitEmpty
+	if !fv.IsValid() {
+		return omitEmpty || f.tag.omitZero
+	}
+	return omitEmpty && isEmptyValue(fv) || f.tag.omitZero && isZeroValue(fv)
+}
+
+// isEmptyValue reports whether v is empty the same way encoding/json does for
+// omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+type isZeroer interface {
+	IsZero() bool
+}
+
+var isZeroerType = reflect.TypeOf((*isZeroer)(nil)).Elem()
+
+// isZeroValue reports whether v is zero for omitzero. Types can define it
+// with IsZero method.
+func isZeroValue(v reflect.Value) bool {
+	switch typ := v.Type(); {
+	case typ.Implements(isZeroerType):
+		if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+			return true
+		}
+		return v.Interface().(isZeroer).IsZero()
+	case v.CanAddr() && reflect.PointerTo(typ).Implements(isZeroerType):
+		return v.Addr().Interface().(isZeroer).IsZero()
+	}
+	return v.IsZero()
 }
 
 func Encode[T any](val T) (map[string]any, error) {
@@ -1,9 +1,14 @@
 package mapx_test
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/netip"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jszwec/mapx"
 
@@ -39,6 +44,29 @@ func (b *Bool) String() string {
 	return strconv.FormatBool(bool(*b))
 }
 
+// zeroer is zero if N is negative.
+type zeroer struct {
+	N int
+}
+
+func (z zeroer) IsZero() bool { return z.N < 0 }
+
+type ptrZeroer struct {
+	N int
+}
+
+func (z *ptrZeroer) IsZero() bool { return z.N < 0 }
+
+func (l Level) MarshalText() ([]byte, error) {
+	switch l {
+	case LevelLow:
+		return []byte("low"), nil
+	case LevelHigh:
+		return []byte("high"), nil
+	}
+	return nil, fmt.Errorf("invalid level: %d", l)
+}
+
 func TestStruct(t *testing.T) {
 	fixtures := []struct {
 		desc string
@@ -270,6 +298,208 @@ func TestStruct(t *testing.T) {
 				"B": 999,
 			},
 		},
+		{
+			desc: "containers of structs",
+			in: &struct {
+				Slice    []Limit
+				Ptrs     []*Limit
+				Array    [2]Limit
+				Map      map[string]Limit
+				IntMap   map[int]*Limit
+				Nested   [][]Limit
+				Ptr      *Limit
+				NilPtr   *Limit
+				NilSlice []Limit
+				Times    []time.Time
+				Raw      []Limit `mapx:",raw"`
+			}{
+				Slice:  []Limit{{Max: 1}},
+				Ptrs:   []*Limit{{Max: 2}, nil},
+				Array:  [2]Limit{{Max: 3}, {Max: 4}},
+				Map:    map[string]Limit{"cpu": {Max: 5}},
+				IntMap: map[int]*Limit{6: {Max: 6}},
+				Nested: [][]Limit{{{Max: 7}}},
+				Ptr:    &Limit{Max: 8},
+				Times:  []time.Time{tm},
+				Raw:    []Limit{{Max: 9}},
+			},
+			out: map[string]any{
+				"Slice":    []any{map[string]any{"max": 1}},
+				"Ptrs":     []any{map[string]any{"max": 2}, nil},
+				"Array":    []any{map[string]any{"max": 3}, map[string]any{"max": 4}},
+				"Map":      map[string]any{"cpu": map[string]any{"max": 5}},
+				"IntMap":   map[string]any{"6": map[string]any{"max": 6}},
+				"Nested":   []any{[]any{map[string]any{"max": 7}}},
+				"Ptr":      map[string]any{"max": 8},
+				"NilPtr":   nil,
+				"NilSlice": nil,
+				"Times":    []time.Time{tm},
+				"Raw":      []Limit{{Max: 9}},
+			},
+		},
+		{
+			desc: "interfaces",
+			in: &struct {
+				Payload  any
+				Ptr      any
+				Nil      any
+				Scalar   any
+				Slice    []any
+				Map      map[string]any
+				Time     any
+				Raw      any `mapx:",raw"`
+				Stringer fmt.Stringer
+			}{
+				Payload:  Limit{Max: 1},
+				Ptr:      &Limit{Max: 2},
+				Scalar:   3,
+				Slice:    []any{Limit{Max: 4}, "a"},
+				Map:      map[string]any{"a": &Limit{Max: 5}, "b": 1},
+				Time:     tm,
+				Raw:      Limit{Max: 6},
+				Stringer: Int(7),
+			},
+			out: map[string]any{
+				"Payload":  map[string]any{"max": 1},
+				"Ptr":      map[string]any{"max": 2},
+				"Nil":      nil,
+				"Scalar":   3,
+				"Slice":    []any{map[string]any{"max": 4}, "a"},
+				"Map":      map[string]any{"a": map[string]any{"max": 5}, "b": 1},
+				"Time":     tm,
+				"Raw":      Limit{Max: 6},
+				"Stringer": Int(7),
+			},
+		},
+		{
+			desc: "interfaces - funcs of dynamic type",
+			in: &struct {
+				Any   any
+				Slice []any
+			}{
+				Any:   Int(1),
+				Slice: []any{Int(2), 3},
+			},
+			opts: mapx.EncoderOpt{
+				EncoderFuncs: stringerEncoder,
+			},
+			out: map[string]any{
+				"Any":   "1",
+				"Slice": []any{"2", 3},
+			},
+		},
+		{
+			desc: "nil - emit",
+			in: &struct {
+				Ptr   *Limit
+				Any   any
+				Slice []int
+				Embedded
+			}{},
+			out: map[string]any{
+				"Ptr":   nil,
+				"Any":   nil,
+				"Slice": []int(nil),
+				"A":     0,
+				"B1":    nil,
+				"Ints":  nil,
+				"Map":   nil,
+			},
+		},
+		{
+			desc: "nil - omit",
+			in: &struct {
+				Ptr   *Limit
+				Any   any
+				Slice []int
+				Inner struct {
+					Ptr *int
+				}
+				Embedded
+			}{},
+			opts: mapx.EncoderOpt{
+				NilPolicy: mapx.EncodeNilOmit,
+			},
+			out: map[string]any{
+				"Inner": map[string]any{},
+				"A":     0,
+			},
+		},
+		{
+			desc: "omitempty",
+			in: &struct {
+				String   string         `mapx:",omitempty"`
+				Int      int            `mapx:",omitempty"`
+				Uint     uint           `mapx:",omitempty"`
+				Float    float64        `mapx:",omitempty"`
+				Bool     bool           `mapx:",omitempty"`
+				Ptr      *int           `mapx:",omitempty"`
+				Any      any            `mapx:",omitempty"`
+				Slice    []int          `mapx:",omitempty"`
+				Map      map[string]int `mapx:",omitempty"`
+				Struct   A              `mapx:",omitempty"`
+				Time     time.Time      `mapx:",omitempty"`
+				NotEmpty int            `mapx:",omitempty"`
+				NoTag    int
+				*B       `mapx:",omitempty"`
+			}{
+				Slice:    []int{},
+				NotEmpty: 1,
+			},
+			out: map[string]any{
+				"Struct": map[string]any{
+					"A1": 0,
+					"B": map[string]any{
+						"B1":   0,
+						"Ints": []int(nil),
+						"Map":  map[string]int(nil),
+					},
+				},
+				"Time":     time.Time{},
+				"NotEmpty": 1,
+				"NoTag":    0,
+			},
+		},
+		{
+			desc: "omitempty - global",
+			in: &struct {
+				String string
+				Int    int
+				Embedded
+			}{
+				Int: 1,
+			},
+			opts: mapx.EncoderOpt{
+				OmitEmpty: true,
+			},
+			out: map[string]any{
+				"Int": 1,
+			},
+		},
+		{
+			desc: "omitzero",
+			in: &struct {
+				Time      time.Time      `mapx:",omitzero"`
+				PtrTime   *time.Time     `mapx:",omitzero"`
+				Struct    B              `mapx:",omitzero"`
+				Slice     []int          `mapx:",omitzero"`
+				Zeroer    zeroer         `mapx:",omitzero"`
+				PtrZeroer ptrZeroer      `mapx:",omitzero"`
+				NotZero   zeroer         `mapx:",omitzero"`
+				EmptyMap  map[string]int `mapx:",omitzero"`
+			}{
+				PtrTime:   &time.Time{},
+				Slice:     nil,
+				Zeroer:    zeroer{N: -1},
+				PtrZeroer: ptrZeroer{N: -1},
+				NotZero:   zeroer{N: 1},
+				EmptyMap:  map[string]int{},
+			},
+			out: map[string]any{
+				"NotZero":  map[string]any{"N": 1},
+				"EmptyMap": map[string]int{},
+			},
+		},
 	}
 
 	for _, f := range fixtures {
@@ -286,3 +516,906 @@ func TestStruct(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeErrorPath(t *testing.T) {
+	errCustom := errors.New("custom error")
+
+	enc := mapx.NewEncoder[*A](mapx.EncoderOpt{
+		EncoderFuncs: mapx.RegisterEncoder(mapx.EncoderFuncs{}, func(n int) (int, error) {
+			if n < 0 {
+				return 0, errCustom
+			}
+			return n, nil
+		}),
+	})
+
+	_, err := enc.Encode(&A{A1: 1, B: B{B1: -1}})
+
+	var eerr *mapx.EncodeError
+	if !errors.As(err, &eerr) {
+		t.Fatalf("expected EncodeError; got %v", err)
+	}
+
+	if eerr.Key != "B.B1" || eerr.Field != "B.B1" {
+		t.Errorf("want key=B.B1 field=B.B1; got key=%s field=%s", eerr.Key, eerr.Field)
+	}
+
+	if !errors.Is(err, errCustom) {
+		t.Errorf("expected %v to be found", errCustom)
+	}
+}
+
+func TestKeyNaming(t *testing.T) {
+	type Inner struct {
+		StreetName string
+		Unit       int `mapx:"unit"`
+	}
+
+	type Nested struct {
+		Inner Inner `mapx:"home,inline"`
+	}
+
+	type S struct {
+		CreatedAt  time.Time
+		HTTPServer string
+		UserID     int
+		Tagged     int    `mapx:"TaggedName"`
+		Billing    Inner  `mapx:"billing,inline"`
+		Plain      Inner  `mapx:",inline"`
+		Nested     Nested `mapx:"n,inline"`
+	}
+
+	in := S{
+		CreatedAt:  tm,
+		HTTPServer: "srv",
+		UserID:     1,
+		Tagged:     2,
+		Billing:    Inner{StreetName: "a", Unit: 3},
+		Plain:      Inner{StreetName: "b", Unit: 4},
+		Nested:     Nested{Inner: Inner{StreetName: "c", Unit: 5}},
+	}
+
+	fixtures := []struct {
+		desc   string
+		naming *mapx.KeyNaming
+		out    map[string]any
+	}{
+		{
+			desc: "none",
+			out: map[string]any{
+				"CreatedAt":         tm,
+				"HTTPServer":        "srv",
+				"UserID":            1,
+				"TaggedName":        2,
+				"billingStreetName": "a",
+				"billingunit":       3,
+				"StreetName":        "b",
+				"unit":              4,
+				"homenStreetName":   "c",
+				"homenunit":         5,
+			},
+		},
+		{
+			desc:   "snake case",
+			naming: mapx.SnakeCase,
+			out: map[string]any{
+				"created_at":          tm,
+				"http_server":         "srv",
+				"user_id":             1,
+				"TaggedName":          2,
+				"billing_street_name": "a",
+				"billing_unit":        3,
+				"street_name":         "b",
+				"unit":                4,
+				"n_home_street_name":  "c",
+				"n_home_unit":         5,
+			},
+		},
+		{
+			desc:   "screaming snake case",
+			naming: mapx.ScreamingSnakeCase,
+			out: map[string]any{
+				"CREATED_AT":          tm,
+				"HTTP_SERVER":         "srv",
+				"USER_ID":             1,
+				"TaggedName":          2,
+				"BILLING_STREET_NAME": "a",
+				"BILLING_unit":        3,
+				"STREET_NAME":         "b",
+				"unit":                4,
+				"N_HOME_STREET_NAME":  "c",
+				"N_HOME_unit":         5,
+			},
+		},
+		{
+			desc:   "kebab case",
+			naming: mapx.KebabCase,
+			out: map[string]any{
+				"created-at":          tm,
+				"http-server":         "srv",
+				"user-id":             1,
+				"TaggedName":          2,
+				"billing-street-name": "a",
+				"billing-unit":        3,
+				"street-name":         "b",
+				"unit":                4,
+				"n-home-street-name":  "c",
+				"n-home-unit":         5,
+			},
+		},
+		{
+			desc:   "camel case",
+			naming: mapx.CamelCase,
+			out: map[string]any{
+				"createdAt":         tm,
+				"httpServer":        "srv",
+				"userId":            1,
+				"TaggedName":        2,
+				"billingStreetName": "a",
+				"billingUnit":       3,
+				"streetName":        "b",
+				"unit":              4,
+				"nHomeStreetName":   "c",
+				"nHomeUnit":         5,
+			},
+		},
+		{
+			desc:   "custom",
+			naming: mapx.NewKeyNaming(strings.ToLower),
+			out: map[string]any{
+				"createdat":         tm,
+				"httpserver":        "srv",
+				"userid":            1,
+				"TaggedName":        2,
+				"billingstreetname": "a",
+				"billingunit":       3,
+				"streetname":        "b",
+				"unit":              4,
+				"nhomestreetname":   "c",
+				"nhomeunit":         5,
+			},
+		},
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.desc, func(t *testing.T) {
+			out, err := mapx.NewEncoder[S](mapx.EncoderOpt{KeyNaming: f.naming}).Encode(in)
+			if err != nil {
+				t.Fatal("expected err=nil; got ", err)
+			}
+
+			if d := cmp.Diff(f.out, out); d != "" {
+				t.Error(d)
+			}
+
+			var dst S
+			if err := mapx.NewDecoder[*S](mapx.DecoderOpt{KeyNaming: f.naming}).Decode(out, &dst); err != nil {
+				t.Fatal("expected err=nil; got ", err)
+			}
+
+			if d := cmp.Diff(in, dst); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	type Doc struct {
+		Slice  []Limit
+		Ptrs   []*Limit
+		Array  [2]Limit
+		Map    map[string]Limit
+		IntMap map[int]*Limit
+		Levels map[Level]Limit
+		Nested [][]Limit
+		Ptr    *Limit
+	}
+
+	fixtures := []struct {
+		desc string
+		in   Doc
+	}{
+		{
+			desc: "populated",
+			in: Doc{
+				Slice:  []Limit{{Max: 1}},
+				Ptrs:   []*Limit{{Max: 2}, nil},
+				Array:  [2]Limit{{Max: 3}, {Max: 4}},
+				Map:    map[string]Limit{"cpu": {Max: 5}},
+				IntMap: map[int]*Limit{6: {Max: 6}},
+				Levels: map[Level]Limit{LevelHigh: {Max: 7}},
+				Nested: [][]Limit{{{Max: 8}}},
+				Ptr:    &Limit{Max: 9},
+			},
+		},
+		{
+			desc: "zero value",
+			in:   Doc{},
+		},
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.desc, func(t *testing.T) {
+			m, err := mapx.Encode(f.in)
+			if err != nil {
+				t.Fatal("expected err=nil; got ", err)
+			}
+
+			var out Doc
+			if err := mapx.Decode(m, &out); err != nil {
+				t.Fatal("expected err=nil; got ", err)
+			}
+
+			if d := cmp.Diff(f.in, out); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestFuncsInContainers(t *testing.T) {
+	type Event struct {
+		At    time.Time `mapx:"at"`
+		Count int       `mapx:"count"`
+	}
+
+	type Doc struct {
+		Times   []time.Time
+		PTimes  []*time.Time
+		ByName  map[string]time.Time
+		Grid    [][]time.Time
+		Arr     [1]time.Time
+		Counts  map[string]int
+		Events  []Event
+		Strings []string
+	}
+
+	encFuncs := mapx.RegisterEncoder(mapx.EncoderFuncs{}, func(t time.Time) (string, error) {
+		return t.Format(time.RFC3339), nil
+	})
+	encFuncs = mapx.RegisterEncoder(encFuncs, func(n int) (string, error) {
+		return strconv.Itoa(n), nil
+	})
+
+	decFuncs := mapx.RegisterDecoder(mapx.DecoderFuncs{}, func(s string, dst *time.Time) (err error) {
+		*dst, err = time.Parse(time.RFC3339, s)
+		return err
+	})
+	decFuncs = mapx.RegisterDecoder(decFuncs, func(s string, dst *int) (err error) {
+		*dst, err = strconv.Atoi(s)
+		return err
+	})
+
+	const ts = "2022-08-04T12:00:00Z"
+
+	in := Doc{
+		Times:   []time.Time{tm},
+		PTimes:  []*time.Time{&tm, nil},
+		ByName:  map[string]time.Time{"start": tm},
+		Grid:    [][]time.Time{{tm}},
+		Arr:     [1]time.Time{tm},
+		Counts:  map[string]int{"a": 1},
+		Events:  []Event{{At: tm, Count: 2}},
+		Strings: []string{"foo"},
+	}
+
+	expected := map[string]any{
+		"Times":   []any{ts},
+		"PTimes":  []any{ts, nil},
+		"ByName":  map[string]any{"start": ts},
+		"Grid":    []any{[]any{ts}},
+		"Arr":     []any{ts},
+		"Counts":  map[string]any{"a": "1"},
+		"Events":  []any{map[string]any{"at": ts, "count": "2"}},
+		"Strings": []string{"foo"},
+	}
+
+	m, err := mapx.NewEncoder[Doc](mapx.EncoderOpt{EncoderFuncs: encFuncs}).Encode(in)
+	if err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	if d := cmp.Diff(expected, m); d != "" {
+		t.Error(d)
+	}
+
+	var out Doc
+	if err := mapx.NewDecoder[*Doc](mapx.DecoderOpt{DecoderFuncs: decFuncs}).Decode(m, &out); err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	if d := cmp.Diff(in, out); d != "" {
+		t.Error(d)
+	}
+}
+
+func TestEncodeAnyFunc(t *testing.T) {
+	type Doc struct {
+		A string
+		L []string
+		M map[string]string
+		N []int
+	}
+
+	encFuncs := mapx.RegisterEncoder(mapx.EncoderFuncs{}, func(v any) (any, error) {
+		switch v := v.(type) {
+		case string:
+			if v == "skip" {
+				return mapx.SkipValue{}, nil
+			}
+			return strings.ToUpper(v), nil
+		case int:
+			return nil, errors.New("unsupported int")
+		}
+		return mapx.NoChange{}, nil
+	})
+
+	enc := mapx.NewEncoder[Doc](mapx.EncoderOpt{EncoderFuncs: encFuncs})
+
+	m, err := enc.Encode(Doc{
+		A: "a",
+		L: []string{"b", "skip"},
+		M: map[string]string{"k": "c", "s": "skip"},
+	})
+	if err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	expected := map[string]any{
+		"A": "A",
+		"L": []any{"B"},
+		"M": map[string]any{"k": "C"},
+		"N": nil,
+	}
+
+	if d := cmp.Diff(expected, m); d != "" {
+		t.Error(d)
+	}
+
+	_, err = enc.Encode(Doc{N: []int{1}})
+
+	var eerr *mapx.EncodeError
+	if !errors.As(err, &eerr) || eerr.Key != "N[0]" {
+		t.Errorf("expected EncodeError for key N[0]; got %v", err)
+	}
+}
+
+func TestEncodeNilError(t *testing.T) {
+	enc := mapx.NewEncoder[any](mapx.EncoderOpt{
+		NilPolicy: mapx.EncodeNilError,
+	})
+
+	_, err := enc.Encode(&struct {
+		Inner struct {
+			Embedded
+		}
+	}{})
+
+	var eerr *mapx.EncodeError
+	if !errors.As(err, &eerr) {
+		t.Fatalf("expected EncodeError; got %v", err)
+	}
+
+	if eerr.Key != "Inner.B1" {
+		t.Errorf("want key=Inner.B1; got %s", eerr.Key)
+	}
+
+	if !errors.Is(err, mapx.ErrNilValue) {
+		t.Errorf("expected %v to be found", mapx.ErrNilValue)
+	}
+}
+
+type Node struct {
+	Name     string           `mapx:"name"`
+	Weight   int              `mapx:"weight,default=1"`
+	Children []Node           `mapx:"children,omitempty"`
+	Parent   *Node            `mapx:"parent,omitempty"`
+	Index    map[string]*Node `mapx:"index,omitempty"`
+}
+
+// Department and Employee are mutually recursive.
+type Department struct {
+	Name    string
+	Manager *Employee
+}
+
+type Employee struct {
+	Name        string
+	Departments []Department
+}
+
+// Team refers to itself through the pointer embedded by Lead.
+type Team struct {
+	Name string
+	Lead Lead
+}
+
+type Lead struct {
+	*Team
+	Level int `mapx:"level,default=1"`
+}
+
+func TestRecursiveTypes(t *testing.T) {
+	t.Run("self-referential", func(t *testing.T) {
+		in := Node{
+			Name:   "root",
+			Weight: 1,
+			Children: []Node{
+				{Name: "a", Weight: 2, Children: []Node{{Name: "b", Weight: 1}}},
+			},
+			Index: map[string]*Node{"c": {Name: "c", Weight: 3}},
+		}
+
+		m, err := mapx.NewEncoder[Node](mapx.EncoderOpt{}).Encode(in)
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		expected := map[string]any{
+			"name":   "root",
+			"weight": 1,
+			"children": []any{
+				map[string]any{
+					"name":     "a",
+					"weight":   2,
+					"children": []any{map[string]any{"name": "b", "weight": 1}},
+				},
+			},
+			"index": map[string]any{"c": map[string]any{"name": "c", "weight": 3}},
+		}
+
+		if d := cmp.Diff(expected, m); d != "" {
+			t.Error(d)
+		}
+
+		// weights are missing and set from defaults.
+		delete(m["index"].(map[string]any)["c"].(map[string]any), "weight")
+		in.Index["c"].Weight = 1
+
+		var out Node
+		if err := mapx.NewDecoder[*Node](mapx.DecoderOpt{}).Decode(m, &out); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if d := cmp.Diff(in, out); d != "" {
+			t.Error(d)
+		}
+	})
+
+	t.Run("mutually recursive", func(t *testing.T) {
+		in := Employee{
+			Name: "alice",
+			Departments: []Department{
+				{Name: "eng", Manager: &Employee{Name: "bob"}},
+			},
+		}
+
+		m, err := mapx.Encode(in)
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		var out Employee
+		if err := mapx.NewDecoder[*Employee](mapx.DecoderOpt{
+			DisallowUnknownKeys: true,
+		}).Decode(m, &out); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if d := cmp.Diff(in, out); d != "" {
+			t.Error(d)
+		}
+	})
+
+	t.Run("recursive through embedded pointer", func(t *testing.T) {
+		m, err := mapx.Encode(&Team{Name: "core", Lead: Lead{Level: 2}})
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		expected := map[string]any{
+			"Name": "core",
+			"Lead": map[string]any{"Name": nil, "Lead": nil, "level": 2},
+		}
+
+		if d := cmp.Diff(expected, m); d != "" {
+			t.Error(d)
+		}
+
+		var out Team
+		if err := mapx.Decode(map[string]any{"Name": "core"}, &out); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if d := cmp.Diff(Team{Name: "core", Lead: Lead{Level: 1}}, out); d != "" {
+			t.Error(d)
+		}
+	})
+}
+
+func TestEncodeCycle(t *testing.T) {
+	root := &Node{Name: "root"}
+	root.Children = []Node{{Name: "a", Parent: root}}
+
+	_, err := mapx.Encode(root)
+
+	var eerr *mapx.EncodeError
+	if !errors.As(err, &eerr) {
+		t.Fatalf("expected EncodeError; got %v", err)
+	}
+
+	if !errors.Is(err, mapx.ErrCycle) {
+		t.Errorf("expected %v to be found", mapx.ErrCycle)
+	}
+
+	if eerr.Key != "children[0].parent" || eerr.Field != "Children[0].Parent" {
+		t.Errorf("want key=children[0].parent field=Children[0].Parent; got key=%s field=%s", eerr.Key, eerr.Field)
+	}
+
+	t.Run("maps", func(t *testing.T) {
+		m := map[string]any{}
+		m["self"] = m
+
+		_, err := mapx.Encode(struct{ M map[string]any }{M: m})
+		if !errors.Is(err, mapx.ErrCycle) {
+			t.Errorf("expected %v; got %v", mapx.ErrCycle, err)
+		}
+	})
+
+	t.Run("shared pointers are not cycles", func(t *testing.T) {
+		l := &Limit{Max: 1}
+
+		m, err := mapx.Encode(struct{ A, B *Limit }{A: l, B: l})
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		expected := map[string]any{
+			"A": map[string]any{"max": 1},
+			"B": map[string]any{"max": 1},
+		}
+
+		if d := cmp.Diff(expected, m); d != "" {
+			t.Error(d)
+		}
+	})
+}
+
+func TestReferences(t *testing.T) {
+	enc := mapx.NewEncoder[*Node](mapx.EncoderOpt{References: true})
+	dec := mapx.NewDecoder[*Node](mapx.DecoderOpt{
+		References:          true,
+		DisallowUnknownKeys: true,
+	})
+
+	shared := &Node{Name: "shared", Weight: 1}
+	root := &Node{Name: "root", Weight: 1}
+	root.Children = []Node{
+		{Name: "a", Weight: 1, Parent: root},
+		{Name: "b", Weight: 1, Parent: shared},
+	}
+	root.Index = map[string]*Node{"shared": shared}
+
+	m, err := enc.Encode(root)
+	if err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	if m[mapx.RefIDKey] == nil {
+		t.Errorf("expected root to have an id; got %v", m)
+	}
+
+	var out Node
+	if err := dec.Decode(m, &out); err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	if out.Children[0].Parent != &out {
+		t.Error("expected parent of a to be the root")
+	}
+
+	if p := out.Children[1].Parent; p == nil || p != out.Index["shared"] || p.Name != "shared" {
+		t.Errorf("expected parent of b to be the shared node; got %+v", p)
+	}
+
+	t.Run("forward reference", func(t *testing.T) {
+		dec := mapx.NewDecoder[any](mapx.DecoderOpt{References: true})
+
+		var out struct {
+			First  *Limit
+			Second *Limit
+		}
+
+		err := dec.Decode(map[string]any{
+			"First":  map[string]any{mapx.RefKey: "1"},
+			"Second": map[string]any{mapx.RefIDKey: "1", "max": 2},
+		}, &out)
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if out.First != out.Second || out.First.Max != 2 {
+			t.Errorf("expected the same pointer; got %v and %v", out.First, out.Second)
+		}
+	})
+
+	t.Run("unknown reference", func(t *testing.T) {
+		var out Node
+		err := dec.Decode(map[string]any{
+			"parent": map[string]any{mapx.RefKey: "1"},
+		}, &out)
+
+		var derr *mapx.DecodeError
+		if !errors.As(err, &derr) || !errors.Is(err, mapx.ErrUnknownReference) {
+			t.Fatalf("expected DecodeError with %v; got %v", mapx.ErrUnknownReference, err)
+		}
+
+		if derr.Key != "parent" {
+			t.Errorf("want key=parent; got %s", derr.Key)
+		}
+	})
+}
+
+// versioned writes its own version key.
+type versioned struct {
+	Name string
+}
+
+func (v versioned) MarshalMapx() (map[string]any, error) {
+	return map[string]any{"v": 2, "name": v.Name}, nil
+}
+
+func (v *versioned) UnmarshalMapx(m map[string]any) error {
+	if m["v"] != 2 {
+		return fmt.Errorf("unsupported version: %v", m["v"])
+	}
+	v.Name, _ = m["name"].(string)
+	return nil
+}
+
+// sparse is a vector that encodes only non-zero elements.
+type sparse []float64
+
+func (s *sparse) MarshalMapx() (map[string]any, error) {
+	m := map[string]any{"len": len(*s)}
+	for i, f := range *s {
+		if f != 0 {
+			m[strconv.Itoa(i)] = f
+		}
+	}
+	return m, nil
+}
+
+func (s *sparse) UnmarshalMapx(m map[string]any) error {
+	*s = make(sparse, m["len"].(int))
+	for k, v := range m {
+		if i, err := strconv.Atoi(k); err == nil {
+			(*s)[i] = v.(float64)
+		}
+	}
+	return nil
+}
+
+func TestMarshaler(t *testing.T) {
+	type Doc struct {
+		Doc     versioned
+		Ptr     *versioned
+		Nil     *versioned
+		Items   []versioned
+		ByName  map[string]versioned
+		Any     any
+		Vector  sparse
+		Vectors []sparse
+	}
+
+	in := Doc{
+		Doc:     versioned{Name: "a"},
+		Ptr:     &versioned{Name: "b"},
+		Items:   []versioned{{Name: "c"}},
+		ByName:  map[string]versioned{"d": {Name: "d"}},
+		Any:     versioned{Name: "e"},
+		Vector:  sparse{0, 1},
+		Vectors: []sparse{{2, 0, 0}},
+	}
+
+	m, err := mapx.Encode(&in)
+	if err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	expected := map[string]any{
+		"Doc":     map[string]any{"v": 2, "name": "a"},
+		"Ptr":     map[string]any{"v": 2, "name": "b"},
+		"Nil":     nil,
+		"Items":   []any{map[string]any{"v": 2, "name": "c"}},
+		"ByName":  map[string]any{"d": map[string]any{"v": 2, "name": "d"}},
+		"Any":     map[string]any{"v": 2, "name": "e"},
+		"Vector":  map[string]any{"len": 2, "1": 1.0},
+		"Vectors": []any{map[string]any{"len": 3, "0": 2.0}},
+	}
+
+	if d := cmp.Diff(expected, m); d != "" {
+		t.Error(d)
+	}
+
+	var out Doc
+	if err := mapx.Decode(m, &out); err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	// interfaces are decoded as they are.
+	in.Any = expected["Any"]
+
+	if d := cmp.Diff(in, out); d != "" {
+		t.Error(d)
+	}
+
+	t.Run("top level", func(t *testing.T) {
+		m, err := mapx.Encode(versioned{Name: "a"})
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		var v versioned
+		if err := mapx.Decode(m, &v); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if v.Name != "a" {
+			t.Errorf("want name=a; got %s", v.Name)
+		}
+
+		s := sparse{0, 3}
+		m, err = mapx.Encode(&s)
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		var out sparse
+		if err := mapx.Decode(m, &out); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if d := cmp.Diff(s, out); d != "" {
+			t.Error(d)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var out Doc
+		err := mapx.Decode(map[string]any{
+			"Items": []any{map[string]any{"v": 1}},
+		}, &out)
+
+		var derr *mapx.DecodeError
+		if !errors.As(err, &derr) {
+			t.Fatalf("expected DecodeError; got %v", err)
+		}
+
+		if derr.Key != "Items[0]" {
+			t.Errorf("want key=Items[0]; got %s", derr.Key)
+		}
+	})
+}
+
+// celsius is encoded into JSON strings, e.g. "21.5C".
+type celsius float64
+
+func (c celsius) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatFloat(float64(c), 'g', -1, 64) + "C")
+}
+
+func (c *celsius) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, "C"), 64)
+	*c = celsius(f)
+	return err
+}
+
+func TestStdInterfaces(t *testing.T) {
+	type Doc struct {
+		Addr    netip.Addr
+		NilAddr *netip.Addr
+		Time    time.Time
+		RawTime time.Time `mapx:",raw"`
+		Levels  []Level
+		Temp    celsius
+		Int     Int
+		Doc     versioned
+	}
+
+	in := Doc{
+		Addr:    netip.MustParseAddr("10.0.0.1"),
+		Time:    tm,
+		RawTime: tm,
+		Levels:  []Level{LevelLow, LevelHigh},
+		Temp:    21.5,
+		Int:     1,
+		Doc:     versioned{Name: "a"},
+	}
+
+	enc := mapx.NewEncoder[Doc](mapx.EncoderOpt{
+		StdInterfaces: mapx.AllStdInterfaces,
+	})
+
+	m, err := enc.Encode(in)
+	if err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	expected := map[string]any{
+		"Addr":    "10.0.0.1",
+		"NilAddr": nil,
+		"Time":    "2022-08-04T12:00:00Z",
+		"RawTime": "2022-08-04T12:00:00Z",
+		"Levels":  []any{"low", "high"},
+		"Temp":    json.RawMessage(`"21.5C"`),
+		"Int":     "1",
+		"Doc":     map[string]any{"v": 2, "name": "a"},
+	}
+
+	if d := cmp.Diff(expected, m); d != "" {
+		t.Error(d)
+	}
+
+	dec := mapx.NewDecoder[*Doc](mapx.DecoderOpt{
+		StdInterfaces: mapx.AllStdInterfaces,
+	})
+
+	// Int is a Stringer only, it can't be decoded from a string.
+	m["Int"] = 1
+
+	var out Doc
+	if err := dec.Decode(m, &out); err != nil {
+		t.Fatal("expected err=nil; got ", err)
+	}
+
+	if d := cmp.Diff(in, out, cmp.Comparer(func(a, b netip.Addr) bool { return a == b })); d != "" {
+		t.Error(d)
+	}
+
+	t.Run("json from values", func(t *testing.T) {
+		var out Doc
+		if err := dec.Decode(map[string]any{"Temp": "-3C"}, &out); err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if out.Temp != -3 {
+			t.Errorf("want -3; got %v", out.Temp)
+		}
+	})
+
+	t.Run("funcs take precedence", func(t *testing.T) {
+		enc := mapx.NewEncoder[Doc](mapx.EncoderOpt{
+			EncoderFuncs: mapx.RegisterEncoder(mapx.EncoderFuncs{}, func(l Level) (int, error) {
+				return int(l), nil
+			}),
+			StdInterfaces: mapx.TextInterfaces,
+		})
+
+		m, err := enc.Encode(Doc{Levels: []Level{LevelHigh}})
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if d := cmp.Diff([]any{int(LevelHigh)}, m["Levels"]); d != "" {
+			t.Error(d)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		m, err := mapx.Encode(Doc{Time: tm})
+		if err != nil {
+			t.Fatal("expected err=nil; got ", err)
+		}
+
+		if m["Time"] != tm {
+			t.Errorf("want %v; got %v", tm, m["Time"])
+		}
+	})
+}
@@ -1,7 +1,6 @@
 package mapx_test
 
 import (
-	"encoding"
 	"fmt"
 	"strconv"
 	"time"
@@ -56,14 +55,11 @@ func ExampleDecoder_Decode() {
 		return nil
 	})
 
-	decoderFuncs = mapx.RegisterDecoder(decoderFuncs, func(s string, tu encoding.TextUnmarshaler) error {
-		return tu.UnmarshalText([]byte(s))
-	})
-
 	// decoders should be global variables.
 	dec := mapx.NewDecoder[*User](mapx.DecoderOpt{
-		DecoderFuncs: decoderFuncs,
-		Tag:          "mapx",
+		DecoderFuncs:  decoderFuncs,
+		Tag:           "mapx",
+		StdInterfaces: mapx.TextInterfaces, // time.Time implements encoding.TextUnmarshaler.
 	})
 
 	m := map[string]any{
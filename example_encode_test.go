@@ -1,7 +1,6 @@
 package mapx_test
 
 import (
-	"encoding"
 	"fmt"
 	"strconv"
 	"time"
@@ -38,15 +37,11 @@ func ExampleEncoder_Encode() {
 		return strconv.Itoa(n), nil
 	})
 
-	encoderFuncs = mapx.RegisterEncoder(encoderFuncs, func(tm encoding.TextMarshaler) (string, error) {
-		text, err := tm.MarshalText()
-		return string(text), err
-	})
-
 	// encoders should be global variables.
 	enc := mapx.NewEncoder[*User](mapx.EncoderOpt{
-		EncoderFuncs: encoderFuncs,
-		Tag:          "mapx",
+		EncoderFuncs:  encoderFuncs,
+		Tag:           "mapx",
+		StdInterfaces: mapx.TextInterfaces, // time.Time implements encoding.TextMarshaler.
 	})
 
 	u := User{
@@ -0,0 +1,31 @@
+package mapx
+
+import (
+	"sort"
+	"strings"
+)
+
+// KeyNormalizer normalizes keys before input keys are matched with field keys.
+type KeyNormalizer func(key string) string
+
+// CaseInsensitive matches keys regardless of their case.
+var CaseInsensitive KeyNormalizer = strings.ToLower
+
+// keyIndex maps normalized keys to the input keys.
+type keyIndex map[string][]string
+
+func newKeyIndex(m map[string]any, normalize KeyNormalizer) keyIndex {
+	idx := make(keyIndex, len(m))
+	for k := range m {
+		nk := normalize(k)
+		idx[nk] = append(idx[nk], k)
+	}
+
+	for _, keys := range idx {
+		if len(keys) > 1 {
+			// map iteration order is random, errors should not be.
+			sort.Strings(keys)
+		}
+	}
+	return idx
+}
@@ -3,13 +3,60 @@ package mapx
 import (
 	"errors"
 	"reflect"
+	"strings"
 )
 
 var (
 	ErrNotAStruct  = errors.New("mapx: provided value is not a struct")
 	ErrNotAPointer = errors.New("mapx: provided value is not a pointer")
+	ErrArrayLength = errors.New("mapx: array length mismatch")
 )
 
+// Errors is returned by Decode when DecoderOpt.AllErrors is set and one or
+// more fields could not be decoded. Each error can be inspected with errors.As.
+type Errors []error
+
+// Error implements error interface.
+func (errs Errors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+
+	var b strings.Builder
+	b.WriteString(errs[0].Error())
+	for _, err := range errs[1:] {
+		b.WriteString("\n")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns all the collected errors.
+func (errs Errors) Unwrap() []error {
+	return errs
+}
+
+// Is implements errors.Is interface.
+func (errs Errors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As implements errors.As interface. It finds the first error that matches
+// target.
+func (errs Errors) As(target any) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 func defaultTag(s string) string {
 	if s == "" {
 		return "mapx"
@@ -17,12 +64,13 @@ func defaultTag(s string) string {
 	return s
 }
 
-func structFields[T any](tag string) fields {
+func structFields[T any](tag string, naming *KeyNaming) fields {
 	typ := walkType(reflect.TypeOf((*T)(nil)).Elem())
 	if typ.Kind() == reflect.Struct {
 		return cachedFields(typeKey{
-			tag:  defaultTag(tag),
-			Type: typ,
+			tag:    defaultTag(tag),
+			naming: naming,
+			Type:   typ,
 		})
 	}
 	return nil
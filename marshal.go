@@ -0,0 +1,68 @@
+package mapx
+
+import "reflect"
+
+// Marshaler is implemented by types that encode themselves into maps.
+// Registered EncoderFuncs take precedence.
+type Marshaler interface {
+	MarshalMapx() (map[string]any, error)
+}
+
+// Unmarshaler is implemented by types that decode themselves from maps.
+// Registered DecoderFuncs take precedence.
+type Unmarshaler interface {
+	UnmarshalMapx(map[string]any) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// isMarshaler reports whether values of typ, or pointers to them, implement
+// Marshaler.
+func isMarshaler(typ reflect.Type) bool {
+	return typ.Implements(marshalerType) || reflect.PointerTo(typ).Implements(marshalerType)
+}
+
+// marshal encodes v with its MarshalMapx method. It reports whether v
+// implements Marshaler. Pointer receivers are used only if v is addressable.
+func marshal(p keyPath, v reflect.Value) (bool, any, error) {
+	typ := v.Type()
+
+	switch {
+	case typ.Implements(marshalerType):
+		if (typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Interface) && v.IsNil() {
+			return true, nil, nil
+		}
+	case reflect.PointerTo(typ).Implements(marshalerType) && v.CanAddr():
+		v = v.Addr()
+	default:
+		return false, nil, nil
+	}
+
+	m, err := v.Interface().(Marshaler).MarshalMapx()
+	switch {
+	case err != nil:
+		return true, nil, newEncodeError(p, typ, err)
+	case m == nil:
+		// avoid typed nil maps in the output.
+		return true, nil, nil
+	}
+	return true, m, nil
+}
+
+// unmarshal decodes v into dst with its UnmarshalMapx method. It reports
+// whether v is a map and dst implements Unmarshaler.
+func unmarshal(v any, dst reflect.Value) (bool, error) {
+	m, ok := v.(map[string]any)
+	if !ok || !dst.CanAddr() || !reflect.PointerTo(dst.Type()).Implements(unmarshalerType) {
+		return false, nil
+	}
+
+	if dst.Kind() == reflect.Map && dst.IsNil() {
+		// value receivers can't allocate the map.
+		dst.Set(reflect.MakeMap(dst.Type()))
+	}
+	return true, dst.Addr().Interface().(Unmarshaler).UnmarshalMapx(m)
+}
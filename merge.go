@@ -0,0 +1,22 @@
+package mapx
+
+// MergePolicy controls how the decoder treats values that are already set in
+// the destination. The zero value replaces slices and maps and decodes into
+// structs that pointers already point to.
+type MergePolicy struct {
+	// AppendSlices appends decoded elements to existing slices instead of
+	// replacing them.
+	AppendSlices bool
+
+	// MergeMaps decodes entries into existing maps instead of replacing them.
+	// Entries missing from the input are kept and existing values are decoded
+	// into, so nested structs are merged too.
+	MergeMaps bool
+
+	// ReallocPointers allocates new structs for pointers that already point to
+	// one, instead of decoding into it.
+	ReallocPointers bool
+
+	// ZeroFirst resets the whole destination to its zero value before decoding.
+	ZeroFirst bool
+}
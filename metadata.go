@@ -0,0 +1,52 @@
+package mapx
+
+import "sort"
+
+// Metadata describes how the input was used by DecodeMetadata. Fields are
+// listed with Go field paths, e.g. "Items[3].Price", and keys with paths of
+// input keys, e.g. "items[3].price". All the lists are sorted.
+type Metadata struct {
+	// Set lists fields that were decoded from the input without errors,
+	// including structs that hold other listed fields.
+	Set []string
+
+	// Keys lists input keys that were decoded into fields.
+	Keys []string
+
+	// Unused lists input keys that didn't match any field.
+	Unused []string
+
+	// Untouched lists fields that were missing from the input. Default values
+	// may have been set for them.
+	Untouched []string
+}
+
+// set records that the field at path p was decoded from the input.
+func (md *Metadata) set(p keyPath) {
+	md.Set = append(md.Set, p.fields())
+	md.Keys = append(md.Keys, p.keys())
+}
+
+// unknown records keys of the struct at path p that didn't match any field.
+// They are decoded into the remain field f if ok is true.
+func (md *Metadata) unknown(p keyPath, keys []string, f field, ok bool) {
+	if ok && len(keys) > 0 {
+		md.Set = append(md.Set, append(p, fieldElem(f)).fields())
+	}
+
+	for _, k := range keys {
+		kp := append(p, pathElem{key: k}).keys()
+		if ok {
+			md.Keys = append(md.Keys, kp)
+		} else {
+			md.Unused = append(md.Unused, kp)
+		}
+	}
+}
+
+func (md *Metadata) sort() {
+	sort.Strings(md.Set)
+	sort.Strings(md.Keys)
+	sort.Strings(md.Unused)
+	sort.Strings(md.Untouched)
+}
@@ -0,0 +1,140 @@
+package mapx
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// KeyNaming converts Go field names of untagged fields and prefixes of inline
+// fields into map keys. It is also used to join the prefixes with keys of
+// their fields.
+//
+// Prefixes of nested inline fields are joined from the outermost field in.
+// A nil *KeyNaming uses field names as they are and keeps the inner prefix
+// first.
+type KeyNaming struct {
+	name func(string) string
+	join func(prefix, key string) string
+}
+
+// NewKeyNaming returns a KeyNaming that converts field names and inline
+// prefixes with f. Inline prefixes are concatenated with keys.
+//
+// KeyNaming is a part of the fields cache key, so it should be created once and
+// stored in a global variable.
+func NewKeyNaming(f func(fieldName string) string) *KeyNaming {
+	return &KeyNaming{name: f}
+}
+
+var (
+	// SnakeCase converts CreatedAt to created_at.
+	SnakeCase = &KeyNaming{
+		name: func(s string) string { return joinWords(s, "_", strings.ToLower) },
+		join: joinSep("_"),
+	}
+
+	// ScreamingSnakeCase converts CreatedAt to CREATED_AT.
+	ScreamingSnakeCase = &KeyNaming{
+		name: func(s string) string { return joinWords(s, "_", strings.ToUpper) },
+		join: joinSep("_"),
+	}
+
+	// KebabCase converts CreatedAt to created-at.
+	KebabCase = &KeyNaming{
+		name: func(s string) string { return joinWords(s, "-", strings.ToLower) },
+		join: joinSep("-"),
+	}
+
+	// CamelCase converts CreatedAt to createdAt.
+	CamelCase = &KeyNaming{
+		name: camelCase,
+		join: func(prefix, key string) string { return prefix + upperFirst(key) },
+	}
+)
+
+func (kn *KeyNaming) fieldName(name string) string {
+	if kn == nil {
+		return name
+	}
+	return kn.name(name)
+}
+
+// key returns the key of a field with the given name under inline prefix.
+func (kn *KeyNaming) key(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	if kn == nil || kn.join == nil {
+		return prefix + name
+	}
+	return kn.join(prefix, name)
+}
+
+func joinSep(sep string) func(string, string) string {
+	return func(prefix, key string) string {
+		return prefix + sep + key
+	}
+}
+
+func joinWords(s, sep string, conv func(string) string) string {
+	words := splitWords(s)
+	for i, w := range words {
+		words[i] = conv(w)
+	}
+	return strings.Join(words, sep)
+}
+
+func camelCase(s string) string {
+	words := splitWords(s)
+	for i, w := range words {
+		if i == 0 {
+			words[i] = strings.ToLower(w)
+			continue
+		}
+		words[i] = upperFirst(strings.ToLower(w))
+	}
+	return strings.Join(words, "")
+}
+
+func upperFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	if n == 0 {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[n:]
+}
+
+// splitWords splits Go identifier into words, e.g. HTTPServerID into HTTP,
+// Server and ID. Digits belong to the preceding word.
+func splitWords(s string) []string {
+	var (
+		runes = []rune(s)
+		words []string
+		start int
+	)
+
+	for i := 1; i < len(runes); i++ {
+		prev, curr := runes[i-1], runes[i]
+
+		switch {
+		case curr == '_':
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+		case unicode.IsUpper(curr) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			words = append(words, string(runes[start:i]))
+			start = i
+		case unicode.IsUpper(prev) && unicode.IsUpper(curr) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+			// end of an acronym, e.g. "HTTPServer".
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+
+	if start < len(runes) && runes[start] != '_' {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
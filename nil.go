@@ -0,0 +1,68 @@
+package mapx
+
+import (
+	"errors"
+	"reflect"
+)
+
+var ErrNilValue = errors.New("mapx: nil value")
+
+// EncodeNilPolicy defines how the encoder handles nil pointers, interfaces,
+// maps and slices, as well as fields promoted through nil embedded pointers.
+type EncodeNilPolicy uint8
+
+const (
+	// EncodeNilEmit writes nil values into the map.
+	EncodeNilEmit EncodeNilPolicy = iota
+
+	// EncodeNilOmit leaves nil values out of the map.
+	EncodeNilOmit
+
+	// EncodeNilError returns EncodeError wrapping ErrNilValue.
+	EncodeNilError
+)
+
+// DecodeNilPolicy defines how the decoder handles nil input values.
+type DecodeNilPolicy uint8
+
+const (
+	// DecodeNilDefault leaves pointers, interfaces, slices and maps unchanged
+	// and returns DecodeError for other types.
+	DecodeNilDefault DecodeNilPolicy = iota
+
+	// DecodeNilZero sets the destination to its zero value.
+	DecodeNilZero
+
+	// DecodeNilKeep leaves the destination unchanged.
+	DecodeNilKeep
+
+	// DecodeNilError returns DecodeError wrapping ErrNilValue.
+	DecodeNilError
+)
+
+// acceptsNil reports whether nil can be decoded into typ under policy.
+func (policy DecodeNilPolicy) acceptsNil(typ reflect.Type) bool {
+	switch policy {
+	case DecodeNilZero, DecodeNilKeep:
+		return true
+	case DecodeNilError:
+		return false
+	}
+	switch typ.Kind() {
+	case reflect.Interface, reflect.Pointer, reflect.Slice, reflect.Map:
+		return true
+	}
+	return false
+}
+
+// isNilValue reports whether v is nil or invalid, i.e. it is promoted through
+// a nil embedded pointer.
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
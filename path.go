@@ -0,0 +1,95 @@
+package mapx
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type pathKind uint8
+
+const (
+	pathField pathKind = iota
+	pathIndex
+	pathMapKey
+)
+
+// pathElem is a single step from the root value to the value being processed.
+// It is either a struct field, a slice index or a map key.
+type pathElem struct {
+	kind   pathKind
+	key    string
+	field  string
+	index  int
+	mapKey reflect.Value
+}
+
+func fieldElem(f field) pathElem {
+	return pathElem{kind: pathField, key: f.name, field: f.goName}
+}
+
+func indexElem(i int) pathElem {
+	return pathElem{kind: pathIndex, index: i}
+}
+
+func mapKeyElem(k reflect.Value) pathElem {
+	return pathElem{kind: pathMapKey, mapKey: k}
+}
+
+// name returns the map key of the element.
+func (e pathElem) name() string {
+	if e.kind == pathMapKey {
+		return fmt.Sprint(e.mapKey.Interface())
+	}
+	return e.key
+}
+
+// keyPath is built while walking values. It is only turned into strings when
+// an error is reported, so it must not be retained - child paths share the
+// same backing array.
+type keyPath []pathElem
+
+// keys returns the path expressed with map keys, e.g. "address.unit" or
+// "items[3].price".
+func (p keyPath) keys() string {
+	var b strings.Builder
+	for _, e := range p {
+		switch e.kind {
+		case pathIndex:
+			writeIndex(&b, strconv.Itoa(e.index))
+		default:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(e.name())
+		}
+	}
+	return b.String()
+}
+
+// fields returns the path expressed with Go struct field names, e.g.
+// "Address.Unit", "Items[3].Price" or "Labels[env]".
+func (p keyPath) fields() string {
+	var b strings.Builder
+	for _, e := range p {
+		switch e.kind {
+		case pathIndex:
+			writeIndex(&b, strconv.Itoa(e.index))
+		case pathMapKey:
+			writeIndex(&b, e.name())
+		default:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(e.field)
+		}
+	}
+	return b.String()
+}
+
+func writeIndex(b *strings.Builder, s string) {
+	b.WriteByte('[')
+	b.WriteString(s)
+	b.WriteByte(']')
+}
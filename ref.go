@@ -0,0 +1,208 @@
+package mapx
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrCycle            = errors.New("mapx: encountered a cycle")
+	ErrUnknownReference = errors.New("mapx: unknown reference")
+)
+
+const (
+	// RefIDKey is the key that identifies a struct referred to elsewhere, when
+	// references are enabled.
+	RefIDKey = "$id"
+
+	// RefKey is the key of a map that refers to a struct by its RefIDKey.
+	RefKey = "$ref"
+)
+
+// visit identifies a pointer, map or slice being encoded.
+type visit struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+func newVisit(v reflect.Value) visit {
+	k := visit{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		k.len = v.Len()
+	}
+	return k
+}
+
+// reference is a struct pointer encoded in reference mode.
+type reference struct {
+	id string
+	m  map[string]any
+}
+
+// encodeState holds the state of a single Encode call.
+type encodeState struct {
+	// visiting holds values that are being encoded to detect cycles.
+	visiting map[visit]struct{}
+
+	// refs holds struct pointers that were encoded, if references are enabled.
+	refs map[visit]*reference
+	ids  int
+}
+
+// enter marks v as being encoded. It returns a reference if v was already
+// encoded in reference mode, or ErrCycle if v is being encoded.
+func (s *encodeState) enter(p keyPath, v reflect.Value) (map[string]any, error) {
+	if v.Pointer() == 0 || v.Kind() == reflect.Slice && v.Len() == 0 {
+		return nil, nil
+	}
+
+	k := newVisit(v)
+
+	if s.refs != nil && v.Kind() == reflect.Pointer && v.Elem().Kind() == reflect.Struct {
+		if r, ok := s.refs[k]; ok {
+			return s.ref(r), nil
+		}
+		s.refs[k] = &reference{}
+		return nil, nil
+	}
+
+	if _, ok := s.visiting[k]; ok {
+		return nil, newEncodeError(p, v.Type(), ErrCycle)
+	}
+
+	if s.visiting == nil {
+		s.visiting = make(map[visit]struct{})
+	}
+	s.visiting[k] = struct{}{}
+	return nil, nil
+}
+
+// leave marks v as encoded into out.
+func (s *encodeState) leave(v reflect.Value, out any) {
+	if v.Pointer() == 0 || v.Kind() == reflect.Slice && v.Len() == 0 {
+		return
+	}
+
+	k := newVisit(v)
+
+	r, ok := s.refs[k]
+	if !ok {
+		delete(s.visiting, k)
+		return
+	}
+
+	m, ok := out.(map[string]any)
+	if !ok {
+		// encoded by a func, it can't be referred to.
+		delete(s.refs, k)
+		return
+	}
+
+	r.m = m
+	if r.id != "" {
+		m[RefIDKey] = r.id
+	}
+}
+
+// ref returns a map that refers to r, assigning an id to r if needed.
+func (s *encodeState) ref(r *reference) map[string]any {
+	if r.id == "" {
+		s.ids++
+		r.id = strconv.Itoa(s.ids)
+		if r.m != nil {
+			r.m[RefIDKey] = r.id
+		}
+	}
+	return map[string]any{RefKey: r.id}
+}
+
+// decodeRefs holds struct pointers decoded in reference mode.
+type decodeRefs struct {
+	ptrs map[string]reflect.Value
+
+	// unresolved holds errors for ids referred to before they were defined.
+	unresolved map[string]*DecodeError
+}
+
+// decodeRef decodes m into dst if it is a reference. If m defines a struct
+// referred to earlier, dst is set to the pointer that was handed out and m is
+// decoded into it. It reports whether m was decoded.
+func (dec *Decoder[T]) decodeRef(s *decodeState, p keyPath, m map[string]any, dst reflect.Value) (bool, error) {
+	if id, ok := m[RefKey]; ok {
+		key := fmt.Sprint(id)
+
+		ptr, ok := s.refs.ptrs[key]
+		switch {
+		case ok && ptr.Type().AssignableTo(dst.Type()):
+			dst.Set(ptr)
+		case !ok && dst.Kind() == reflect.Pointer && dst.Type().Elem().Kind() == reflect.Struct:
+			// defined later.
+			ptr = reflect.New(dst.Type().Elem())
+			s.refs.ptrs[key] = ptr
+			if s.refs.unresolved == nil {
+				s.refs.unresolved = make(map[string]*DecodeError)
+			}
+			s.refs.unresolved[key] = newDecodeError(p, m, dst.Type(), ErrUnknownReference)
+			dst.Set(ptr)
+		case !ok:
+			return true, s.fail(newDecodeError(p, m, dst.Type(), ErrUnknownReference))
+		default:
+			return true, s.fail(newDecodeError(p, m, dst.Type(), nil))
+		}
+		return true, nil
+	}
+
+	id, ok := m[RefIDKey]
+	if !ok || dst.Kind() != reflect.Pointer {
+		return false, nil
+	}
+
+	key := fmt.Sprint(id)
+	if _, ok := s.refs.unresolved[key]; !ok {
+		return false, nil
+	}
+
+	ptr := s.refs.ptrs[key]
+	if !ptr.Type().AssignableTo(dst.Type()) {
+		return true, s.fail(newDecodeError(p, m, dst.Type(), nil))
+	}
+
+	delete(s.refs.unresolved, key)
+	dst.Set(ptr)
+
+	// the pointer was handed out, it must not be reallocated.
+	return true, dec.decodeValue(s, p, m, ptr.Elem(), nil)
+}
+
+// define registers dst, which is decoded from m, if m has an id.
+func (refs *decodeRefs) define(m map[string]any, dst reflect.Value) {
+	id, ok := m[RefIDKey]
+	if !ok || !dst.CanAddr() {
+		return
+	}
+
+	key := fmt.Sprint(id)
+	if _, ok := refs.ptrs[key]; !ok {
+		refs.ptrs[key] = dst.Addr()
+	}
+}
+
+// err returns errors of references that were never defined.
+func (refs *decodeRefs) err(s *decodeState) error {
+	ids := make([]string, 0, len(refs.unresolved))
+	for id := range refs.unresolved {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if err := s.fail(refs.unresolved[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
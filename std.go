@@ -0,0 +1,136 @@
+package mapx
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// StdInterfaces is a set of standard library interfaces that the encoder and
+// decoder use for values without registered funcs.
+//
+// Registered funcs take precedence, then Marshaler and Unmarshaler, then the
+// interfaces in the order they are listed below. Like registered funcs, they
+// are used for raw fields too - raw only stops structs from being encoded
+// into maps.
+type StdInterfaces uint8
+
+const (
+	// TextInterfaces encodes encoding.TextMarshaler values into strings and
+	// decodes strings into encoding.TextUnmarshaler values.
+	TextInterfaces StdInterfaces = 1 << iota
+
+	// JSONInterfaces encodes json.Marshaler values into json.RawMessage and
+	// decodes values into json.Unmarshaler values. []byte values, including
+	// json.RawMessage, are passed as they are, other values are marshaled
+	// with encoding/json first.
+	JSONInterfaces
+
+	// StringerInterface encodes fmt.Stringer values into strings. It is
+	// ignored by the decoder.
+	StringerInterface
+
+	// AllStdInterfaces enables all the interfaces.
+	AllStdInterfaces = TextInterfaces | JSONInterfaces | StringerInterface
+)
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// implements returns v, or its address if only pointers implement iface, and
+// reports whether either of them implements iface.
+func implements(v reflect.Value, iface reflect.Type) (reflect.Value, bool) {
+	switch {
+	case v.Type().Implements(iface):
+		return v, true
+	case v.CanAddr() && reflect.PointerTo(v.Type()).Implements(iface):
+		return v.Addr(), true
+	}
+	return v, false
+}
+
+func (std StdInterfaces) has(typ reflect.Type) bool {
+	ptr := reflect.PointerTo(typ)
+	return std&TextInterfaces != 0 && ptr.Implements(textMarshalerType) ||
+		std&JSONInterfaces != 0 && ptr.Implements(jsonMarshalerType) ||
+		std&StringerInterface != 0 && ptr.Implements(stringerType)
+}
+
+// encode encodes v with the first interface it implements. It reports
+// whether any was found.
+func (std StdInterfaces) encode(p keyPath, v reflect.Value) (bool, any, error) {
+	if std == 0 {
+		return false, nil, nil
+	}
+
+	var (
+		out any
+		err error
+	)
+
+	if iv, ok := implements(v, textMarshalerType); ok && std&TextInterfaces != 0 {
+		if isNilValue(iv) {
+			return true, nil, nil
+		}
+
+		var text []byte
+		text, err = iv.Interface().(encoding.TextMarshaler).MarshalText()
+		out = string(text)
+	} else if iv, ok := implements(v, jsonMarshalerType); ok && std&JSONInterfaces != 0 {
+		if isNilValue(iv) {
+			return true, nil, nil
+		}
+
+		var b []byte
+		b, err = iv.Interface().(json.Marshaler).MarshalJSON()
+		out = json.RawMessage(b)
+	} else if iv, ok := implements(v, stringerType); ok && std&StringerInterface != 0 {
+		if isNilValue(iv) {
+			return true, nil, nil
+		}
+		out = iv.Interface().(fmt.Stringer).String()
+	} else {
+		return false, nil, nil
+	}
+
+	if err != nil {
+		return true, nil, newEncodeError(p, v.Type(), err)
+	}
+	return true, out, nil
+}
+
+// decode decodes v into dst, which must be addressable, with the first
+// interface that accepts v. It reports whether any was found.
+func (std StdInterfaces) decode(v any, dst reflect.Value) (bool, error) {
+	if std == 0 {
+		return false, nil
+	}
+
+	ptr := dst.Addr().Interface()
+
+	if tu, ok := ptr.(encoding.TextUnmarshaler); ok && std&TextInterfaces != 0 {
+		switch v := v.(type) {
+		case string:
+			return true, tu.UnmarshalText([]byte(v))
+		case []byte:
+			return true, tu.UnmarshalText(v)
+		}
+	}
+
+	if ju, ok := ptr.(json.Unmarshaler); ok && std&JSONInterfaces != 0 {
+		val := reflect.ValueOf(v)
+		if val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8 {
+			return true, ju.UnmarshalJSON(val.Bytes())
+		}
+
+		b, err := json.Marshal(v)
+		if err != nil {
+			return true, err
+		}
+		return true, ju.UnmarshalJSON(b)
+	}
+	return false, nil
+}
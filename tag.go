@@ -10,20 +10,28 @@ type tag struct {
 	name      string
 	prefix    string
 	tagname   string
+	naming    *KeyNaming
 	empty     bool
 	omitEmpty bool
+	omitZero  bool
 	ignore    bool
 	inline    bool
 	raw       bool
+	required  bool
+	remain    bool
+
+	hasDefault bool
+	defaultVal string
 }
 
-func parseTag(tagname string, field reflect.StructField) (t tag) {
-	t.tagname = tagname
+func parseTag(k typeKey, field reflect.StructField) (t tag) {
+	t.tagname = k.tag
+	t.naming = k.naming
 	t.raw = isKnownStruct(walkType(field.Type))
 
-	tags := strings.Split(field.Tag.Get(tagname), ",")
+	tags := strings.Split(field.Tag.Get(k.tag), ",")
 	if len(tags) == 1 && tags[0] == "" {
-		t.name = field.Name
+		t.name = k.naming.fieldName(field.Name)
 		t.empty = true
 		return
 	}
@@ -33,27 +41,61 @@ func parseTag(tagname string, field reflect.StructField) (t tag) {
 		t.ignore = true
 		return
 	case "":
-		t.name = field.Name
+		t.name = k.naming.fieldName(field.Name)
 	default:
 		t.name = tags[0]
 	}
 
-	for _, tagOpt := range tags[1:] {
+	opts := tags[1:]
+	for i := 0; i < len(opts); i++ {
+		tagOpt := opts[i]
+
+		if strings.HasPrefix(tagOpt, "default=") {
+			// default value can contain commas, so it takes the following
+			// elements up to the next known option.
+			vals := []string{tagOpt[len("default="):]}
+			for i+1 < len(opts) && !isTagOption(opts[i+1]) {
+				i++
+				vals = append(vals, opts[i])
+			}
+
+			t.hasDefault = true
+			t.defaultVal = strings.Join(vals, ",")
+			continue
+		}
+
 		switch tagOpt {
 		case "omitempty":
 			t.omitEmpty = true
+		case "omitzero":
+			t.omitZero = true
 		case "inline":
 			if walkType(field.Type).Kind() == reflect.Struct {
 				t.inline = true
-				t.prefix = tags[0]
+				t.prefix = k.naming.fieldName(tags[0])
 			}
 		case "raw":
 			t.raw = true
+		case "required":
+			t.required = true
+		case "remain":
+			if field.Type.ConvertibleTo(mapType) {
+				t.remain = true
+			}
 		}
 	}
 	return
 }
 
+// isTagOption reports whether s is an option that can follow a default value.
+func isTagOption(s string) bool {
+	switch s {
+	case "omitempty", "omitzero", "inline", "raw", "required", "remain":
+		return true
+	}
+	return strings.HasPrefix(s, "default=")
+}
+
 func walkType(typ reflect.Type) reflect.Type {
 	for typ.Kind() == reflect.Pointer {
 		typ = typ.Elem()
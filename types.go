@@ -0,0 +1,142 @@
+package mapx
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var ErrUnknownType = errors.New("mapx: unknown type")
+
+// Types maps discriminator values to concrete types of interfaces. Values
+// held by interfaces with registered types are encoded with the discriminator
+// key, which the decoder uses to pick the concrete type.
+//
+// Types should be created once and stored in a global variable.
+type Types struct {
+	m map[reflect.Type]typeSet
+}
+
+// typeSet holds concrete types of a single interface.
+type typeSet struct {
+	key   string
+	types map[string]reflect.Type
+	names map[reflect.Type]string
+}
+
+func (ts Types) clone() Types {
+	if ts.m == nil {
+		return Types{m: make(map[reflect.Type]typeSet)}
+	}
+
+	m := make(map[reflect.Type]typeSet, len(ts.m))
+	for k, v := range ts.m {
+		set := typeSet{
+			key:   v.key,
+			types: make(map[string]reflect.Type, len(v.types)),
+			names: make(map[reflect.Type]string, len(v.names)),
+		}
+		for name, typ := range v.types {
+			set.types[name] = typ
+			set.names[typ] = name
+		}
+		m[k] = set
+	}
+	return Types{m: m}
+}
+
+// RegisterType registers T as the concrete type of interface I held by values
+// with the name under key. T can be a pointer type, e.g. *Circle, then the
+// decoder stores pointers in the interface.
+//
+// All types of a single interface must use the same key.
+func RegisterType[I, T any](ts Types, key, name string) Types {
+	var (
+		iface = reflect.TypeOf((*I)(nil)).Elem()
+		typ   = reflect.TypeOf((*T)(nil)).Elem()
+	)
+
+	if iface.Kind() != reflect.Interface {
+		panic("mapx: RegisterType: " + iface.String() + " is not an interface")
+	}
+
+	if !typ.Implements(iface) {
+		panic("mapx: RegisterType: " + typ.String() + " does not implement " + iface.String())
+	}
+
+	out := ts.clone()
+
+	set, ok := out.m[iface]
+	if !ok {
+		set = typeSet{
+			key:   key,
+			types: make(map[string]reflect.Type),
+			names: make(map[reflect.Type]string),
+		}
+	}
+
+	if set.key != key {
+		panic(fmt.Sprintf("mapx: RegisterType: %s uses key %q, not %q", iface, set.key, key))
+	}
+
+	set.types[name] = typ
+	set.names[typ] = name
+	out.m[iface] = set
+	return out
+}
+
+// decodeType decodes m into dst, which is an interface with registered types.
+// It reports whether dst has any types registered.
+func (dec *Decoder[T]) decodeType(s *decodeState, p keyPath, m map[string]any, dst reflect.Value) (bool, error) {
+	set, ok := dec.opt.Types.m[dst.Type()]
+	if !ok {
+		return false, nil
+	}
+
+	name, _ := m[set.key].(string)
+	typ, ok := set.types[name]
+	if !ok {
+		err := fmt.Errorf("%w: %q", ErrUnknownType, name)
+		return true, s.fail(newDecodeError(p, m[set.key], dst.Type(), err))
+	}
+
+	// the discriminator is not a field of the concrete type.
+	s.typeKey = set.key
+
+	v := reflect.New(typ).Elem()
+	err := dec.decodeValue(s, p, m, v, nil)
+	s.typeKey = ""
+	if err != nil {
+		return true, err
+	}
+
+	dst.Set(v)
+	return true, nil
+}
+
+// encodeType encodes v, which is an interface with registered types, and
+// writes the discriminator. It reports whether v has any types registered.
+func (e *Encoder[T]) encodeType(s *encodeState, p keyPath, v reflect.Value) (bool, any, error) {
+	set, ok := e.opts.Types.m[v.Type()]
+	if !ok {
+		return false, nil, nil
+	}
+
+	name, ok := set.names[v.Elem().Type()]
+	if !ok {
+		return true, nil, newEncodeError(p, v.Elem().Type(), ErrUnknownType)
+	}
+
+	out, err := e.encodeValue(s, p, v.Elem(), nil)
+	if err != nil {
+		return true, nil, err
+	}
+
+	m, ok := out.(map[string]any)
+	if !ok {
+		return true, nil, newEncodeError(p, v.Elem().Type(), errors.New("registered type is not encoded into a map"))
+	}
+
+	m[set.key] = name
+	return true, m, nil
+}
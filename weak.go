@@ -0,0 +1,112 @@
+package mapx
+
+import (
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decodeWeak converts val to dst for DecoderOpt.WeaklyTypedInput. It reports
+// whether the conversion is supported for the given kinds.
+func decodeWeak(val, dst reflect.Value) (bool, error) {
+	switch val.Kind() {
+	case reflect.String:
+		return decodeWeakString(val.String(), dst)
+	case reflect.Bool:
+		var n int64
+		if val.Bool() {
+			n = 1
+		}
+
+		switch dst.Kind() {
+		case reflect.String:
+			dst.SetString(strconv.FormatBool(val.Bool()))
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			dst.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			dst.SetUint(uint64(n))
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(float64(n))
+		default:
+			return false, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch dst.Kind() {
+		case reflect.String:
+			dst.SetString(strconv.FormatInt(val.Int(), 10))
+		case reflect.Bool:
+			dst.SetBool(val.Int() != 0)
+		default:
+			return false, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch dst.Kind() {
+		case reflect.String:
+			dst.SetString(strconv.FormatUint(val.Uint(), 10))
+		case reflect.Bool:
+			dst.SetBool(val.Uint() != 0)
+		default:
+			return false, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch dst.Kind() {
+		case reflect.String:
+			dst.SetString(strconv.FormatFloat(val.Float(), 'f', -1, val.Type().Bits()))
+		case reflect.Bool:
+			dst.SetBool(val.Float() != 0)
+		default:
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func decodeWeakString(s string, dst reflect.Value) (bool, error) {
+	if s == "" && dst.Kind() != reflect.Interface {
+		dst.Set(reflect.Zero(dst.Type()))
+		return true, nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if dst.Type() == durationType {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return true, err
+			}
+			dst.SetInt(int64(d))
+			return true, nil
+		}
+
+		n, err := strconv.ParseInt(s, 10, dst.Type().Bits())
+		if err != nil {
+			return true, err
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, dst.Type().Bits())
+		if err != nil {
+			return true, err
+		}
+		dst.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, dst.Type().Bits())
+		if err != nil {
+			return true, err
+		}
+		dst.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return true, err
+		}
+		dst.SetBool(b)
+	default:
+		return false, nil
+	}
+	return true, nil
+}